@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/anomalroil/go-tinylib-wrapper/tinylib"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
 )
 
@@ -22,10 +26,12 @@ func main() {
 
 	// To specify the location of the tinygarble executable, the circuit used and the clock cycles, as well as wether this circuit enforce the use of the --input flag if it has more than 1 clock cycles
 	rootPtr := flag.String("r", os.Getenv("TINYGARBLE"), "the TinyGarble root directory path, default to $TINYGARBLE if var set, writes $TINYGARBLE if changed")
-	circuitPtr := flag.String("n", "aes_1cc.scd", "name of the circuit file located in the circuit root directory")
+	circuitPtr := flag.String("n", "aes_1cc.scd", "name of the circuit file located in the circuit root directory, the AES key size is taken from it: aes_192_1cc.scd and aes_256_1cc.scd take 48 and 64 hex chars keys")
+	keySizePtr := flag.Int("keysize", 0, "the AES key size in bytes of the circuit, 16, 24 or 32, needed if its name isn't one of TinyGarble's such as aes_1cc.scd")
 	circuitPathPtr := flag.String("c", "$TINYGARBLE/scd/netlists", "location of the circuit root directory.")
 	clockcyclesPtr := flag.Int("cc", 1, "number of clock cycles needed for this circuit, usually 1, usually indicated at the end of the circuit name, sha3_24cc needs 24 clock cycles for example")
 	forceInputPtr := flag.Bool("input", false, "some circuits are using more than 1 clock cycles but don't use the init flag in TinyGarble. This allows to enforce the use of the --input flag instead of the --init one.")
+	argvPtr := flag.Bool("argv", false, "give the data to TinyGarble on its command line, for TinyGarble builds without --input_file support. Anyone on the host can then read it, so don't use it with a secret key.")
 
 	portsPtr := flag.Int("p", 1234, "Specify a starting port")
 	addrPtr := flag.String("s", "127.0.0.1", "Specify a server address for Bob to connect.")
@@ -34,6 +40,9 @@ func main() {
 	bobPtr := flag.Bool("b", false, "run as client Bob")
 	ctrPtr := flag.Bool("ctr", false, "run using CTR mode and aes circuit in 1cc")
 	cbcPtr := flag.Bool("cbc", false, "run using CBC mode and aes circuit in 1cc")
+	tunnelPtr := flag.Bool("tunnel", false, "with -control, Bob reaches Alice's garblers through the control connection, so that only Alice's control port has to be reachable")
+	controlPtr := flag.Bool("control", false, "with -cbc or -ctr, Alice serves a control channel on the given port, which Bob uses to ask for the port of each block, instead of using the next ports")
+	paddingPtr := flag.String("padding", "cts", "with -cbc, how to deal with data which isn't a whole number of blocks: cts (ciphertext stealing, which needs at least 32 hex chars), pkcs7 or iso7816")
 	customIv := flag.String("iv", "", "allows to specify a custom IV for the CTR mode, only for testing : using custom IV may be dangerous, since CTR is sensible to randomness reuses")
 	initPtr := flag.String("d", "00000000000000000000000000000000", "Init data")
 	flag.Parse()
@@ -57,33 +66,137 @@ func main() {
 	}
 
 	circuitPath += *circuitPtr
-	tinylib.SetCircuit(tinyPath, circuitPath, *clockcyclesPtr, *forceInputPtr)
+	padding, ok := map[string]tinylib.Padding{
+		"cts":     tinylib.PadCTS,
+		"pkcs7":   tinylib.PadPKCS7,
+		"iso7816": tinylib.PadISO7816,
+	}[*paddingPtr]
+	if !ok {
+		log.Fatal("Unknown padding ", *paddingPtr, ", please use cts, pkcs7 or iso7816.")
+	}
+
+	inputMode := tinylib.InputAuto
+	if *forceInputPtr {
+		inputMode = tinylib.InputFlag
+	}
+	backend := tinylib.TinyGarbleBackend{Path: tinyPath, ArgvInput: *argvPtr}
+	if ok, err := backend.SupportsInputFile(context.Background()); err == nil && !ok && !*argvPtr {
+		log.Fatal("This TinyGarble doesn't support --input_file, please use -argv, knowing that anyone on the host can then read the inputs.")
+	}
+	opts := []tinylib.Option{tinylib.WithBackend(backend), tinylib.WithCircuit(circuitPath),
+		tinylib.WithClockCycles(*clockcyclesPtr), tinylib.WithInputMode(inputMode), tinylib.WithPadding(padding)}
+	if *keySizePtr != 0 {
+		opts = append(opts, tinylib.WithKeySize(*keySizePtr))
+	}
+	session, err := tinylib.NewSession(opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// sanity check for the input
-	if len(*initPtr) < 32 && (*ctrPtr || *cbcPtr) {
-		log.Fatal("Please give an init value of length 32 at least until I've implemented padding.")
+	// sanity check for the input: Alice's key has the size of the circuit's one, and Bob's data is 128 bits at least for the ciphertext stealing
+	if (*ctrPtr || *cbcPtr) && *alicePtr {
+		keySize, err := session.KeySize()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(*initPtr) != 2*keySize {
+			log.Fatalf("Please give a key of length %d for the circuit %s.", 2*keySize, *circuitPtr)
+		}
+	}
+	if len(*initPtr) < 32 && *cbcPtr && *bobPtr && padding == tinylib.PadCTS {
+		log.Fatal("Please give an init value of length 32 at least, or use -padding pkcs7 or iso7816.")
 	}
 
 	// we can continue, everything is initialized.
+	// Interrupting the program kills the running TinyGarble process instead of leaving it behind
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	switch {
 	case (*cbcPtr || *ctrPtr) && *alicePtr:
-		fmt.Println("Launching AES CTR server with key:", *initPtr)
+		// The key is Alice's secret, it doesn't belong in a terminal or a log file
+		fmt.Println("Launching AES CTR server with key:", tinylib.Secret(*initPtr))
 		// Note the change of endianness for the data, since the AES_1cc uses little endian
-		// Run for ever since -1 is decremented
-		tinylib.RunServer(tinylib.ReverseEndianness(*initPtr), *portsPtr, -1)
+		key, err := tinylib.SwapEndianness(*initPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *controlPtr {
+			// Bob will ask for the circuit by its file name
+			cs := tinylib.NewControlServer()
+			cs.Handle(*circuitPtr, session, key)
+			err = cs.ListenAndServe(ctx, fmt.Sprintf(":%d", *portsPtr))
+		} else {
+			// Run for ever since -1 is decremented
+			err = session.RunServer(ctx, key, *portsPtr, -1)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
 		fmt.Println("AES Server terminated")
+	case (*cbcPtr || *ctrPtr) && *bobPtr && *controlPtr:
+		dial := session.Dial
+		if *tunnelPtr {
+			dial = session.DialTunnel
+		}
+		blocks := tinylib.BlockCount(*initPtr)
+		if *cbcPtr && padding != tinylib.PadCTS {
+			blocks = tinylib.PaddedBlockCount(*initPtr)
+		}
+		conn, err := dial(ctx, fmt.Sprintf("%s:%d", *addrPtr, *portsPtr), *circuitPtr, blocks)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+		plaintext, err := hex.DecodeString(*initPtr)
+		if err != nil {
+			log.Fatal("Please give the data in hexadecimal: ", err)
+		}
+		iv, err := tinylib.NewIV()
+		if *ctrPtr && *customIv != "" {
+			var custom []byte
+			if custom, err = hex.DecodeString(*customIv); err == nil && len(custom) != tinylib.BlockSize {
+				err = errors.New("the iv has to be 128 bits in hexadecimal")
+			}
+			copy(iv[:], custom)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		var cipher []byte
+		if *ctrPtr {
+			cipher, err = tinylib.CTREncrypt(conn.Block(ctx), plaintext, iv)
+		} else {
+			cipher, err = tinylib.CBCEncrypt(conn.Block(ctx), plaintext, iv)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Data encrypted as:", tinylib.SplitData(strings.ToUpper(hex.EncodeToString(cipher)), 32))
+		fmt.Println("with", hex.EncodeToString(iv[:]), "as an iv.")
 	case *ctrPtr && *bobPtr:
-		cipher, ivUsed := tinylib.AESCTR(*initPtr, *addrPtr, *portsPtr, *customIv)
+		cipher, ivUsed, err := session.AESCTR(ctx, *initPtr, *addrPtr, *portsPtr, *customIv)
+		if err != nil {
+			log.Fatal(err)
+		}
 		fmt.Println("Data encrypted in CTR mode as:", cipher)
 		fmt.Println("with", ivUsed, "as an iv.")
 	case *cbcPtr && *bobPtr:
-		cipher, ivUsed := tinylib.AESCBC(*initPtr, *addrPtr, *portsPtr, "")
+		cipher, ivUsed, err := session.AESCBC(ctx, *initPtr, *addrPtr, *portsPtr, "")
+		if err != nil {
+			log.Fatal(err)
+		}
 		fmt.Println("Data encrypted in CBC mode as:", cipher)
 		fmt.Println("with", ivUsed, "as an iv.")
 	case *alicePtr:
-		tinylib.YaoServer(*initPtr, *portsPtr)
+		if err := session.Server(ctx, *initPtr, *portsPtr); err != nil {
+			log.Fatal(err)
+		}
 	case *bobPtr:
-		ret := tinylib.YaoClient(*initPtr, *addrPtr, *portsPtr)
+		ret, err := session.Client(ctx, *initPtr, *addrPtr, *portsPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
 		fmt.Println("Client's return value:", ret)
 	default: // if running neither as Alice, nor as Bob, there is a misuse
 		log.Fatal("Please run as server Alice (-a) first and then as Bob (-b). Use -h for help.")
@@ -0,0 +1,190 @@
+package tinylib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Circuit describes the circuit a Backend has to evaluate
+type Circuit struct {
+	// Path is the location of the circuit, for TinyGarble the .scd file
+	Path string
+	// ClockCycles is the number of clock cycles needed by the circuit
+	ClockCycles int
+	// Input tells how the input data is given to the circuit
+	Input InputMode
+}
+
+// A Backend is an engine able to run one evaluation of a two party circuit, either as Alice who garbles it, or as Bob who evaluates it.
+// The inputs and outputs are hexadecimal strings, as TinyGarble uses them.
+// TinyGarbleBackend is the one used by default, but the modes of operation only depend on this interface.
+type Backend interface {
+	// Garble runs Alice's side: it waits on the given port for Bob and garbles the circuit with Alice's input.
+	Garble(ctx context.Context, c Circuit, input string, port int) error
+	// Evaluate runs Bob's side: it connects to Alice on addr:port and returns the output of the circuit.
+	Evaluate(ctx context.Context, c Circuit, input string, addr string, port int) (string, error)
+}
+
+// The context key telling that a garbler is only reached through the loopback
+type loopbackOnlyKey struct{}
+
+// Marks the context of garblers which are only reached through the loopback, as in a tunnelled control session
+func withLoopbackOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loopbackOnlyKey{}, true)
+}
+
+// LoopbackOnly tells a Backend's Garble that its garbler is only reached through the loopback, e.g. because Bob goes
+// through the tunnel of a control session, so that it should listen on 127.0.0.1 rather than on every interface.
+func LoopbackOnly(ctx context.Context) bool {
+	only, _ := ctx.Value(loopbackOnlyKey{}).(bool)
+	return only
+}
+
+// How long we wait for TinyGarble's output to be closed once it has been killed
+const waitDelay = time.Second
+
+// TinyGarbleBackend runs the TinyGarble executable found in the given TinyGarble root directory.
+//
+// The inputs, such as Alice's AES key, are never put on TinyGarble's command line, where anyone on the host could read
+// them using ps or /proc/<pid>/cmdline. They are written into a temporary file only readable by us, which is given to
+// TinyGarble using --input_file or --init_file and wiped once TinyGarble is done, so a TinyGarble build supporting those
+// flags is needed. This also allows inputs too large for the command line. The upstream TinyGarble doesn't have them:
+// before its first run, the executable's --help is checked for them, and ErrInputFileUnsupported is returned if they
+// are missing, rather than running TinyGarble with flags it doesn't know.
+//
+// TinyGarble always listens on every interface, it has no flag for the address to listen on, so LoopbackOnly can't be
+// honoured: behind a tunnelled control session, the garblers' ports still have to be firewalled, otherwise whoever
+// reaches them first can connect instead of Bob.
+type TinyGarbleBackend struct {
+	Path string
+	// ArgvInput gives the inputs on the command line using --input or --init instead, as the upstream TinyGarble expects them.
+	// Only use it with non secret inputs, or on a host you are the only one to use.
+	ArgvInput bool
+}
+
+// Garble runs TinyGarble as Alice. It returns ErrPortUnavailable if something is already listening on the port and a
+// *TinyGarbleError if TinyGarble fails.
+func (b TinyGarbleBackend) Garble(ctx context.Context, c Circuit, input string, port int) error {
+	if err := checkPort(port); err != nil {
+		return err
+	}
+
+	yaoArgs := []string{"-a", "-i", c.Path,
+		"-p", strconv.Itoa(port)}
+
+	_, err := b.run(ctx, c, yaoArgs, input)
+	return err
+}
+
+// Evaluate runs TinyGarble as Bob and returns its raw output. If TinyGarble fails, the error is a *TinyGarbleError holding its exit code.
+// When TinyGarble complains about connecting to Alice, the error also matches ErrConnectFailed.
+func (b TinyGarbleBackend) Evaluate(ctx context.Context, c Circuit, input string, addr string, port int) (string, error) {
+	// we will use the following arguments when we run the client :
+	yaoArgs := []string{"-b", "-i", c.Path,
+		"-s", addr, "-p", strconv.Itoa(port),
+		"--output_mode", "2"}
+	// We specify the --output_mode arg to be "last_clock", aka 2, only, since otherwise it would output each clock cycle intermediate states when using multiple cycles circuits
+
+	out, err := b.run(ctx, c, yaoArgs, input)
+	var tgErr *TinyGarbleError
+	if errors.As(err, &tgErr) && strings.Contains(strings.ToLower(tgErr.Stderr), "connect") {
+		return "", fmt.Errorf("%w: %w", ErrConnectFailed, err)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// Runs TinyGarble with the given arguments and input, and returns its output. The process is killed as soon as the context
+// is done, and it is always waited for, so that no zombie is left behind.
+func (b TinyGarbleBackend) run(ctx context.Context, c Circuit, args []string, input string) ([]byte, error) {
+	if b.ArgvInput {
+		args = append(args, inputArgs(c, input, false)...)
+	} else {
+		ok, err := b.SupportsInputFile(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrInputFileUnsupported, b.binary())
+		}
+		path, remove, err := writeSecretFile(input)
+		if err != nil {
+			return nil, fmt.Errorf("tinylib: writing TinyGarble's input: %w", err)
+		}
+		defer remove()
+		args = append(args, inputArgs(c, path, true)...)
+	}
+
+	cmd := exec.CommandContext(ctx, b.binary(), args...)
+	// In case TinyGarble left a child holding its output open, we don't want to wait for it for ever
+	cmd.WaitDelay = waitDelay
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("tinylib: TinyGarble stopped: %w", context.Cause(ctx))
+		}
+		tgErr := tinyGarbleError(err)
+		// TinyGarble may complain about our input, which shouldn't end up in the logs of the caller
+		tgErr.Stderr = redact(tgErr.Stderr, input)
+		return nil, tgErr
+	}
+	return out, nil
+}
+
+// The TinyGarble executables known to support --input_file, so that --help is only run once for each of them
+var inputFileSupport sync.Map
+
+// SupportsInputFile tells whether the TinyGarble executable has the --input_file and --init_file flags, by looking for
+// them in its --help. If TinyGarble can't be run at all, it answers true, so that running it reports the actual error.
+func (b TinyGarbleBackend) SupportsInputFile(ctx context.Context) (bool, error) {
+	bin := b.binary()
+	if _, ok := inputFileSupport.Load(bin); ok {
+		return true, nil
+	}
+	cmd := exec.CommandContext(ctx, bin, "--help")
+	cmd.WaitDelay = waitDelay
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return false, fmt.Errorf("tinylib: TinyGarble stopped: %w", context.Cause(ctx))
+	}
+	var execErr *exec.Error
+	if errors.As(err, &execErr) || (err != nil && cmd.ProcessState == nil) {
+		return true, nil
+	}
+	if !bytes.Contains(out, []byte("--input_file")) || !bytes.Contains(out, []byte("--init_file")) {
+		return false, nil
+	}
+	inputFileSupport.Store(bin, true)
+	return true, nil
+}
+
+// The path to the TinyGarble executable
+func (b TinyGarbleBackend) binary() string {
+	return b.Path + "/bin/garbled_circuit/TinyGarble"
+}
+
+// Builds the clock cycles and input arguments for the given circuit, the input being given either directly or as the path of a file
+func inputArgs(c Circuit, data string, file bool) []string {
+	var inputArg []string
+	if c.ClockCycles > 1 {
+		inputArg = []string{"--clock_cycle", strconv.Itoa(c.ClockCycles)}
+	}
+
+	flag := "--init"
+	if c.Input == InputFlag || (c.Input == InputAuto && c.ClockCycles <= 1) {
+		flag = "--input"
+	}
+	if file {
+		flag += "_file"
+	}
+	return append(inputArg, flag, data)
+}
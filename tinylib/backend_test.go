@@ -0,0 +1,149 @@
+package tinylib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A backend recording what the session asks it, and answering a fixed output
+type stubBackend struct {
+	mu     sync.Mutex
+	inputs []string
+	ports  []int
+	output string
+}
+
+func (b *stubBackend) Garble(ctx context.Context, c Circuit, input string, port int) error {
+	return nil
+}
+
+func (b *stubBackend) Evaluate(ctx context.Context, c Circuit, input string, addr string, port int) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inputs = append(b.inputs, input)
+	b.ports = append(b.ports, port)
+	return b.output, nil
+}
+
+// The modes of operation only go through the Backend interface
+func TestSessionWithBackend(t *testing.T) {
+	b := &stubBackend{output: "000102030405060708090A0B0C0D0E0F\n"}
+	s, err := NewSession(WithBackend(b), WithCircuit("aes_1cc.scd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iv := "00000000000000000000000000000001"
+	ans, _, err := s.AESCTR(context.Background(), "0000000000000000000000000000000000", "127.0.0.1", 4000, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The counter is given to the backend in little endian, and its output reversed back
+	if !reflect.DeepEqual(b.inputs, []string{"01000000000000000000000000000000", "02000000000000000000000000000000"}) {
+		t.Error("Unexpected backend inputs", b.inputs)
+	}
+	if !reflect.DeepEqual(b.ports, []int{4000, 4001}) {
+		t.Error("Unexpected backend ports", b.ports)
+	}
+	if !reflect.DeepEqual(ans, []string{"0F0E0D0C0B0A09080706050403020100", "0F"}) {
+		t.Error("Unexpected ciphertext", ans)
+	}
+}
+
+// The input must reach TinyGarble through a file, never through its command line, and never show up in errors
+func TestTinyGarbleSecretInput(t *testing.T) {
+	key := "2b7e151628aed2a6abf7158809cf4f3c"
+	root := fakeTinyGarble(t, `echo "$@" > "$(dirname "$0")/args"
+while [ $# -gt 0 ]; do
+	if [ "$1" = "--input_file" ]; then cat "$2"; echo "$2" > "$(dirname "$0")/file"; fi
+	shift
+done
+exit 1`)
+
+	err := TinyGarbleBackend{Path: root}.Garble(context.Background(), Circuit{Path: "aes_1cc.scd", ClockCycles: 1}, key, 0)
+	var tgErr *TinyGarbleError
+	if !errors.As(err, &tgErr) {
+		t.Fatal("Expected a *TinyGarbleError, got", err)
+	}
+	args, _ := os.ReadFile(filepath.Join(root, "bin", "garbled_circuit", "args"))
+	if strings.Contains(string(args), key) || !strings.Contains(string(args), "--input_file") {
+		t.Error("The key shouldn't be on the command line:", string(args))
+	}
+	file, _ := os.ReadFile(filepath.Join(root, "bin", "garbled_circuit", "file"))
+	if _, err := os.Stat(strings.TrimSpace(string(file))); !os.IsNotExist(err) {
+		t.Error("Expected the input file to be removed, got", err)
+	}
+
+	// With ArgvInput, TinyGarble echoing the key on stderr mustn't leak it
+	root = fakeTinyGarble(t, `echo "bad input $7" >&2; exit 1`)
+	err = TinyGarbleBackend{Path: root, ArgvInput: true}.Garble(context.Background(), Circuit{Path: "aes_1cc.scd"}, key, 0)
+	if !errors.As(err, &tgErr) || tgErr.Stderr != "bad input [REDACTED]\n" {
+		t.Error("Expected the key to be redacted from stderr, got", err, tgErr.Stderr)
+	}
+}
+
+// An upstream TinyGarble, which only has --input and --init, must not be run with --input_file, but fail clearly, unless
+// ArgvInput is set
+func TestTinyGarbleWithoutInputFile(t *testing.T) {
+	key := "2b7e151628aed2a6abf7158809cf4f3c"
+	root := fakeTinyGarbleHelp(t, "  --input arg\n  --init arg", `echo "$@" > "$(dirname "$0")/args"; exit 0`)
+	b := TinyGarbleBackend{Path: root}
+	if ok, err := b.SupportsInputFile(context.Background()); ok || err != nil {
+		t.Error("Expected no --input_file support, got", ok, err)
+	}
+	err := b.Garble(context.Background(), Circuit{Path: "aes_1cc.scd", ClockCycles: 1}, key, 0)
+	if !errors.Is(err, ErrInputFileUnsupported) {
+		t.Error("Expected ErrInputFileUnsupported, got", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "bin", "garbled_circuit", "args")); !os.IsNotExist(err) {
+		t.Error("TinyGarble shouldn't have been run, got", err)
+	}
+
+	b.ArgvInput = true
+	if err := b.Garble(context.Background(), Circuit{Path: "aes_1cc.scd", ClockCycles: 1}, key, 0); err != nil {
+		t.Fatal(err)
+	}
+	args, _ := os.ReadFile(filepath.Join(root, "bin", "garbled_circuit", "args"))
+	if !strings.Contains(string(args), "--input "+key) {
+		t.Error("Expected the key to be given with --input, got", string(args))
+	}
+
+	// A missing TinyGarble is reported by running it, as before
+	err = TinyGarbleBackend{Path: t.TempDir()}.Garble(context.Background(), Circuit{Path: "aes_1cc.scd"}, key, 0)
+	var tgErr *TinyGarbleError
+	if !errors.As(err, &tgErr) || tgErr.ExitCode != -1 {
+		t.Error("Expected a *TinyGarbleError, got", err)
+	}
+}
+
+// The input flag depends on the clock cycles and the input mode, exactly as SetCircuit's uInput did
+func TestInputArgs(t *testing.T) {
+	tests := []struct {
+		cc   int
+		mode InputMode
+		want []string
+	}{
+		{1, InputAuto, []string{"--input", "AB"}},
+		{8, InputAuto, []string{"--clock_cycle", "8", "--init", "AB"}},
+		{8, InputFlag, []string{"--clock_cycle", "8", "--input", "AB"}},
+		{1, InputInit, []string{"--init", "AB"}},
+		{8, InputAuto, []string{"--clock_cycle", "8", "--init_file", "AB"}},
+	}
+	for _, tt := range tests {
+		s, err := NewSession(WithTinyGarble("/opt/TinyGarble"), WithCircuit("c.scd"),
+			WithClockCycles(tt.cc), WithInputMode(tt.mode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := strings.HasSuffix(tt.want[len(tt.want)-2], "_file")
+		if got := inputArgs(s.circuit, "AB", file); !reflect.DeepEqual(got, tt.want) {
+			t.Error("Expected", tt.want, "got", got)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package tinylib
+
+import (
+	"context"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// GarbledBlock is a cipher.Block whose key is held by Alice: each block encryption is one evaluation of the garbled
+// AES circuit against her garblers, so that the standard library modes, such as cipher.NewCTR, cipher.NewCBCEncrypter
+// or cipher.NewGCM, can be used on top of it. It takes care of the little endian convention of TinyGarble's circuit.
+// The evaluations are done one at a time, so a GarbledBlock is safe for concurrent use, but note that each one uses
+// one of Alice's garblers: she has to run as many rounds as there will be blocks encrypted.
+type GarbledBlock struct {
+	ctx context.Context
+	mu  sync.Mutex
+	p   peer
+	// The counter layout of the CTR streams and the padding of the CBC ones on top of it
+	counter CounterLayout
+	padding Padding
+}
+
+// Check that we implement the interface
+var _ cipher.Block = (*GarbledBlock)(nil)
+
+// Block returns a GarbledBlock evaluating the session's AES circuit against the garblers of RunServer, on the
+// consecutive ports starting at port. The context bounds all of its evaluations, since cipher.Block takes none.
+func (s *Session) Block(ctx context.Context, addr string, port int) *GarbledBlock {
+	return &GarbledBlock{ctx: ctx, p: &portSchedule{session: s, addr: addr, port: port}, counter: s.counter, padding: s.padding}
+}
+
+// Block returns a GarbledBlock asking Alice for a garbler over the control session for each block.
+// The context bounds all of its evaluations, since cipher.Block takes none.
+func (c *Conn) Block(ctx context.Context) *GarbledBlock {
+	return &GarbledBlock{ctx: ctx, p: c, counter: c.session.counter, padding: c.session.padding}
+}
+
+// BlockSize returns the AES block size, 16 bytes
+func (b *GarbledBlock) BlockSize() int {
+	return BlockSize
+}
+
+// Encrypt encrypts the first block of src into dst, which may overlap entirely. Since cipher.Block can't return an
+// error, Encrypt panics if the evaluation fails, use EncryptBlock to get the error instead.
+func (b *GarbledBlock) Encrypt(dst, src []byte) {
+	if err := b.EncryptBlock(dst, src); err != nil {
+		panic(err)
+	}
+}
+
+// EncryptBlock works as Encrypt, but returns the error of the evaluation.
+func (b *GarbledBlock) EncryptBlock(dst, src []byte) error {
+	if len(src) < BlockSize || len(dst) < BlockSize {
+		return fmt.Errorf("%w: input or output not a full block", ErrDataTooShort)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return evaluateBlock(b.ctx, b.p, dst[:BlockSize], src[:BlockSize])
+}
+
+// Decrypt isn't supported by the AES circuit, it always panics. The modes only using the block encryption, such as
+// CTR, GCM, CFB or OFB, work both ways.
+func (b *GarbledBlock) Decrypt(dst, src []byte) {
+	panic(errors.New("tinylib: the garbled AES circuit can't decrypt"))
+}
@@ -0,0 +1,90 @@
+package tinylib
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"errors"
+	"testing"
+)
+
+// The standard library CTR mode on top of the garbled AES gives the SP 800-38A F.5.1 vectors. Thanks to
+// GarbledBlock.NewCTR, it only uses one garbler per block.
+func TestBlockCTR(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	port := startAES(t, s, "2b7e151628aed2a6abf7158809cf4f3c", 2)
+
+	b := s.Block(context.Background(), "127.0.0.1", port)
+	if b.BlockSize() != 16 {
+		t.Error("Expected a block size of 16, got", b.BlockSize())
+	}
+	pt := mustHex(t, "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e51")
+	ct := make([]byte, len(pt))
+	cipher.NewCTR(b, mustHex(t, "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")).XORKeyStream(ct, pt)
+	expected := mustHex(t, "874d6191b620e3261bef6864990db6ce9806f66b7970fdff8617187bb9fffdff")
+	if !bytes.Equal(ct, expected) {
+		t.Errorf("Expected %x, got %x", expected, ct)
+	}
+}
+
+// The standard library CBC mode gives the SP 800-38A F.2.1 vectors
+func TestBlockCBC(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	port := startAES(t, s, "2b7e151628aed2a6abf7158809cf4f3c", 2)
+
+	b := s.Block(context.Background(), "127.0.0.1", port)
+	pt := mustHex(t, "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e51")
+	ct := make([]byte, len(pt))
+	cipher.NewCBCEncrypter(b, mustHex(t, "000102030405060708090a0b0c0d0e0f")).CryptBlocks(ct, pt)
+	expected := mustHex(t, "7649abac8119b246cee98e9b12e9197d5086cb9b507219ee95db113a917678b2")
+	if !bytes.Equal(ct, expected) {
+		t.Errorf("Expected %x, got %x", expected, ct)
+	}
+}
+
+// The standard library GCM, test case 2 of the GCM specification: one evaluation for the hash key, one for the tag
+// and one for the data
+func TestBlockGCM(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	port := startAES(t, s, "00000000000000000000000000000000", 3)
+
+	gcm, err := cipher.NewGCM(s.Block(context.Background(), "127.0.0.1", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := gcm.Seal(nil, make([]byte, 12), make([]byte, 16), nil)
+	expected := mustHex(t, "0388dace60b6a392f328c2b971b2fe78ab6e47d42cec13bdf53a67b21257bddf")
+	if !bytes.Equal(sealed, expected) {
+		t.Errorf("Expected %x, got %x", expected, sealed)
+	}
+}
+
+// Encrypt panics when the evaluation fails, EncryptBlock returns the error
+func TestBlockErrors(t *testing.T) {
+	s, err := NewSession(WithBackend(ReferenceBackend{}), WithCircuit("aes_1cc.scd"), WithRetry(1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := s.Block(context.Background(), "127.0.0.1", reservePorts(t, 1))
+
+	block := make([]byte, 16)
+	if err := b.EncryptBlock(block, block); !errors.Is(err, ErrConnectFailed) {
+		t.Error("Expected ErrConnectFailed, got", err)
+	}
+	if err := b.EncryptBlock(block, block[:15]); !errors.Is(err, ErrDataTooShort) {
+		t.Error("Expected ErrDataTooShort, got", err)
+	}
+	for name, f := range map[string]func(){
+		"Encrypt": func() { b.Encrypt(block, block) },
+		"Decrypt": func() { b.Decrypt(block, block) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error(name, "didn't panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
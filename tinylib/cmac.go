@@ -0,0 +1,122 @@
+package tinylib
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// AESCMAC computes the AES-CMAC of RFC 4493 over the hexadecimal data, of any length, under Alice's key: Bob learns
+// the MAC, but neither the key nor the subkeys derived from it, and Alice doesn't learn the data. The session's circuit
+// is the AES one, garbled on the consecutive ports starting at port: one round for the subkeys, and one per block
+// of data, or one for empty data. The MAC is returned as an uppercase hexadecimal string.
+func (s *Session) AESCMAC(ctx context.Context, data string, addr string, port int) (string, error) {
+	return aesCMAC(s.Block(ctx, addr, port), data)
+}
+
+// CMAC works as AESCMAC with bytes.
+func (s *Session) CMAC(ctx context.Context, message []byte, addr string, port int) ([BlockSize]byte, error) {
+	return CMAC(s.Block(ctx, addr, port), message)
+}
+
+// The hexadecimal layer of CMAC
+func aesCMAC(b *GarbledBlock, data string) (string, error) {
+	message, err := hex.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidHex, err)
+	}
+	mac, err := CMAC(b, message)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(mac[:])), nil
+}
+
+// CMAC computes the AES-CMAC of RFC 4493 of the message as Session.CMAC does, every AES call being an evaluation of the
+// garbled block, e.g. of Conn.Block over a control session. It is CBC-MAC, i.e. the last block of the CBC encryption
+// with a zero IV, of the message whose last block is xored with a subkey: K1 if it is a full block, K2 once padded
+// otherwise.
+func CMAC(b *GarbledBlock, message []byte) ([BlockSize]byte, error) {
+	fmt.Println("\tAES CMAC started")
+	k1, k2, err := cmacSubkeys(b)
+	if err != nil {
+		return [BlockSize]byte{}, err
+	}
+	defer clear(k1[:])
+	defer clear(k2[:])
+	return cmacWith(b, &k1, &k2, message)
+}
+
+// CMAC with subkeys already derived, so that several messages only need them once
+func cmacWith(b *GarbledBlock, k1, k2 *[BlockSize]byte, message []byte) ([BlockSize]byte, error) {
+	full := len(message) / BlockSize
+	var last [BlockSize]byte
+	defer clear(last[:])
+	if len(message) > 0 && len(message)%BlockSize == 0 {
+		full--
+		copy(last[:], message[full*BlockSize:])
+		subtle.XORBytes(last[:], last[:], k1[:])
+	} else {
+		n := copy(last[:], message[full*BlockSize:])
+		padBlock(last[:], n, PadISO7816)
+		subtle.XORBytes(last[:], last[:], k2[:])
+	}
+	return cbcMAC(b, message[:full*BlockSize], last[:])
+}
+
+// CBC-MAC, i.e. the last block of the CBC encryption with a zero IV, of the parts put together, which have to be a
+// whole number of blocks. It uses the chaining of CBCWriter, whose ciphertext we don't need but for its last block,
+// which never pads nor steals whatever the padding of the block.
+func cbcMAC(b *GarbledBlock, parts ...[]byte) ([BlockSize]byte, error) {
+	tail := &lastBlock{}
+	w := NewCBCWriter(tail, b, [BlockSize]byte{})
+	w.padding = PadCTS
+	for _, part := range parts {
+		if _, err := w.Write(part); err != nil {
+			w.Close()
+			return [BlockSize]byte{}, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return [BlockSize]byte{}, err
+	}
+	return tail.block, nil
+}
+
+// The subkeys of RFC 4493, derived from L = E(0^128), which is computed by the garbled circuit
+func cmacSubkeys(b *GarbledBlock) (k1, k2 [BlockSize]byte, err error) {
+	var l [BlockSize]byte
+	defer clear(l[:])
+	if err := b.EncryptBlock(l[:], l[:]); err != nil {
+		return k1, k2, err
+	}
+	double(&k1, &l)
+	double(&k2, &k1)
+	return k1, k2, nil
+}
+
+// Multiplies the block by x in GF(2^128), as big endian and modulo x^128 + x^7 + x^2 + x + 1, in constant time
+func double(dst, src *[BlockSize]byte) {
+	msb := src[0] >> 7
+	for i := 0; i < BlockSize-1; i++ {
+		dst[i] = src[i]<<1 | src[i+1]>>7
+	}
+	dst[BlockSize-1] = src[BlockSize-1]<<1 ^ byte(subtle.ConstantTimeSelect(int(msb), 0x87, 0))
+}
+
+// A writer keeping only the last block written to it
+type lastBlock struct {
+	block [BlockSize]byte
+}
+
+func (l *lastBlock) Write(p []byte) (int, error) {
+	if len(p) >= BlockSize {
+		copy(l.block[:], p[len(p)-BlockSize:])
+	} else {
+		copy(l.block[:], l.block[len(p):])
+		copy(l.block[BlockSize-len(p):], p)
+	}
+	return len(p), nil
+}
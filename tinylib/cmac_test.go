@@ -0,0 +1,107 @@
+package tinylib
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The examples of RFC 4493 section 4
+var cmacVectors = []struct {
+	message, mac string
+}{
+	{"", "bb1d6929e95937287fa37d129b756746"},
+	{"6bc1bee22e409f96e93d7e117393172a", "070a16b46b4d4144f79bdd9dd04a287c"},
+	{"6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411", "dfa66747de9ae63030ca32611497c827"},
+	{"6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710", "51f0bebf7e3b9d92fc49741779363cfe"},
+}
+
+const cmacKey = "2b7e151628aed2a6abf7158809cf4f3c"
+
+// The subkeys of RFC 4493 section 4
+func TestCMACSubkeys(t *testing.T) {
+	enc, _ := localBlocks(t, cmacKey, PadCTS)
+	k1, k2, err := cmacSubkeys(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(k1[:]) != "fbeed618357133667c85e08f7236a8de" {
+		t.Errorf("Unexpected K1 %x", k1)
+	}
+	if hex.EncodeToString(k2[:]) != "f7ddac306ae266ccf90bc11ee46d513b" {
+		t.Errorf("Unexpected K2 %x", k2)
+	}
+}
+
+// The RFC 4493 examples through the garblers, the padding of the session not mattering
+func TestAESCMAC(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd", WithPadding(PadPKCS7))
+	for _, v := range cmacVectors {
+		blocks := 1 + max(1, BlockCount(v.message))
+		mac, err := s.AESCMAC(context.Background(), v.message, "127.0.0.1", startAES(t, s, cmacKey, blocks))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mac != strings.ToUpper(v.mac) {
+			t.Errorf("%d bytes: expected %s, got %s", len(v.message)/2, v.mac, mac)
+		}
+	}
+	if _, err := s.AESCMAC(context.Background(), "0g", "127.0.0.1", 1); !errors.Is(err, ErrInvalidHex) {
+		t.Error("Expected ErrInvalidHex, got", err)
+	}
+}
+
+// Every length around the blocks, with the bytes API, uses the right number of evaluations
+func TestCMACBlocks(t *testing.T) {
+	p := newCountingPeer(t, cmacKey)
+	b := &GarbledBlock{ctx: context.Background(), p: p}
+	message := mustHex(t, cmacVectors[3].message)
+	for _, v := range cmacVectors {
+		p.calls = 0
+		mac, err := CMAC(b, message[:len(v.message)/2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(mac[:]) != v.mac {
+			t.Errorf("%d bytes: expected %s, got %x", len(v.message)/2, v.mac, mac)
+		}
+		if expected := 1 + max(1, BlockCount(v.message)); p.calls != expected {
+			t.Errorf("%d bytes: expected %d evaluations, got %d", len(v.message)/2, expected, p.calls)
+		}
+	}
+}
+
+// CMACs computed concurrently on the same block take their garblers one at a time, never the same port twice
+func TestCMACConcurrent(t *testing.T) {
+	backend := &stubBackend{output: "000102030405060708090A0B0C0D0E0F\n"}
+	s, err := NewSession(WithBackend(backend), WithCircuit("aes_1cc.scd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := s.Block(context.Background(), "127.0.0.1", 4000)
+	message := mustHex(t, cmacVectors[2].message)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := CMAC(b, message); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	seen := make(map[int]bool)
+	for _, port := range backend.ports {
+		if seen[port] {
+			t.Error("Port", port, "used twice")
+		}
+		seen[port] = true
+	}
+	if len(seen) != 4*4 {
+		t.Error("Expected 16 evaluations, got", len(seen))
+	}
+}
@@ -0,0 +1,516 @@
+package tinylib
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The control protocol lets Bob ask Alice, on a single well known port, for a garbler for each of his evaluations,
+// instead of both of them following the fixed port schedule of RunServer. It is made of JSON messages, one per line:
+//
+//	Bob   {"type":"open","circuit":"aes_1cc.scd","blocks":3}
+//	Alice {"type":"ok"}
+//	Bob   {"type":"next"}
+//	Alice {"type":"port","port":49153}   once the garbler listens on that port, Bob then evaluates the circuit on it
+//	...
+//	Bob   {"type":"done"}                Alice stops the remaining garblers and closes the connection
+//
+// Alice answers {"type":"error","error":"..."} to any message she can't fulfill.
+//
+// If Bob adds "tunnel":true to his open message, both switch to multiplexing the connection right after Alice's ok:
+// the control messages then go through its stream 0, and Bob reaches each garbler through a stream of its own instead
+// of connecting to its port, so that the control port is the only port of Alice which has to be reachable.
+const (
+	msgOpen  = "open"
+	msgOK    = "ok"
+	msgNext  = "next"
+	msgPort  = "port"
+	msgDone  = "done"
+	msgError = "error"
+)
+
+// A message of the control protocol
+type controlMessage struct {
+	Type    string `json:"type"`
+	Circuit string `json:"circuit,omitempty"`
+	Blocks  int    `json:"blocks,omitempty"`
+	Port    int    `json:"port,omitempty"`
+	Tunnel  bool   `json:"tunnel,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// The longest control message, any longer line fails the session rather than being buffered without end
+const maxControlLine = 4096
+
+// One end of a control connection
+type controlConn struct {
+	// The TCP connection, closing it ends the session
+	conn net.Conn
+	w    io.Writer
+	r    *bufio.Reader
+}
+
+func newControlConn(conn net.Conn) *controlConn {
+	return &controlConn{conn: conn, w: conn, r: bufio.NewReaderSize(conn, maxControlLine)}
+}
+
+// Switches the connection to multiplexing, the control messages then use the stream 0. Only Alice accepts streams.
+func (c *controlConn) tunnel(accept bool) *mux {
+	m := newMux(c.conn, c.r, accept)
+	control := m.control()
+	c.w = control
+	c.r = bufio.NewReaderSize(control, maxControlLine)
+	return m
+}
+
+func (c *controlConn) write(m controlMessage) error {
+	line, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = c.w.Write(append(line, '\n'))
+	return err
+}
+
+func (c *controlConn) read() (controlMessage, error) {
+	var m controlMessage
+	line, err := c.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return m, fmt.Errorf("tinylib: control message longer than %d bytes", maxControlLine)
+	}
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(line, &m)
+	return m, err
+}
+
+// Sends an error message, the error of the write itself is not interesting since we are already failing
+func (c *controlConn) fail(err error) {
+	c.write(controlMessage{Type: msgError, Error: err.Error()})
+}
+
+// ControlServer is Alice's side of the control protocol. It offers sessions, along with Alice's input for them, under a
+// name Bob uses to ask for them, usually the name of the circuit file. It runs until its context is done, for as many
+// Bobs and evaluations as needed.
+type ControlServer struct {
+	mu       sync.RWMutex
+	services map[string]service
+	ports    *PortManager
+}
+
+// What Alice offers under a name: a circuit to garble with a given input
+type service struct {
+	session *Session
+	input   string
+}
+
+// NewControlServer returns a ControlServer offering nothing yet, see Handle.
+func NewControlServer() *ControlServer {
+	return &ControlServer{services: make(map[string]service)}
+}
+
+// Handle offers to garble the circuit of the session with Alice's input, for Bobs asking for the given name.
+// For AES, the input is the key, in little endian as for RunServer.
+func (cs *ControlServer) Handle(name string, s *Session, input string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.services[name] = service{session: s, input: input}
+}
+
+// HandleKey offers the session's AES circuit with the given key, as bytes in the usual big endian order. Its size has
+// to be the one of the circuit, see Session.KeySize.
+func (cs *ControlServer) HandleKey(name string, s *Session, key []byte) error {
+	input, err := s.aesKey(key)
+	if err != nil {
+		return err
+	}
+	cs.Handle(name, s, input)
+	return nil
+}
+
+// UsePorts makes the garblers listen on ports handed out by pm, rather than on any port the system picks.
+// This is useful when only some ports are open in the firewall.
+func (cs *ControlServer) UsePorts(pm *PortManager) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.ports = pm
+}
+
+func (cs *ControlServer) lookup(name string) (service, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	svc, ok := cs.services[name]
+	return svc, ok
+}
+
+// ListenAndServe listens on the given address, such as ":1234", and serves Bob's control sessions on it until the
+// context is done. It returns ErrPortUnavailable if it can't listen.
+func (cs *ControlServer) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPortUnavailable, err)
+	}
+	return cs.Serve(ctx, l)
+}
+
+// Serve serves Bob's control sessions accepted on the listener until the context is done, and then returns the context
+// error once every garbler has been stopped.
+func (cs *ControlServer) Serve(ctx context.Context, l net.Listener) error {
+	defer l.Close()
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+
+	var sessions sync.WaitGroup
+	defer sessions.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		sessions.Add(1)
+		go func() {
+			defer sessions.Done()
+			if err := cs.serveConn(ctx, conn); err != nil {
+				fmt.Printf("\tControl session with %s failed: %v\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Runs one control session with a Bob
+func (cs *ControlServer) serveConn(ctx context.Context, conn net.Conn) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var garblers sync.WaitGroup
+	// Bob is done or gone: we stop the garblers he didn't use and wait for them before leaving
+	defer garblers.Wait()
+	defer cancel()
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	ch := newControlConn(conn)
+	open, err := ch.read()
+	if err != nil {
+		return err
+	}
+	if open.Type != msgOpen {
+		err = fmt.Errorf("tinylib: expected an open message, got %q", open.Type)
+		ch.fail(err)
+		return err
+	}
+	svc, ok := cs.lookup(open.Circuit)
+	if !ok {
+		err = fmt.Errorf("tinylib: unknown circuit %q", open.Circuit)
+		ch.fail(err)
+		return err
+	}
+	if err = ch.write(controlMessage{Type: msgOK}); err != nil {
+		return err
+	}
+	// The ports of the garblers started for this Bob, the only ones he may reach through the tunnel
+	var ports sync.Map
+	if open.Tunnel {
+		// The garblers are reached through our loopback only
+		ctx = withLoopbackOnly(ctx)
+		m := ch.tunnel(true)
+		garblers.Add(1)
+		go func() {
+			defer garblers.Done()
+			acceptStreams(ctx, m, &ports)
+		}()
+	}
+
+	used := 0
+	for {
+		m, err := ch.read()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		switch m.Type {
+		case msgDone:
+			return nil
+		case msgNext:
+		default:
+			err = fmt.Errorf("tinylib: unexpected %q message", m.Type)
+			ch.fail(err)
+			return err
+		}
+
+		if open.Blocks > 0 && used >= open.Blocks {
+			ch.fail(fmt.Errorf("tinylib: the %d announced blocks have already been used", open.Blocks))
+			continue
+		}
+		port, err := cs.startGarbler(ctx, &garblers, svc)
+		if err != nil {
+			ch.fail(err)
+			continue
+		}
+		used++
+		ports.Store(port, true)
+		if err = ch.write(controlMessage{Type: msgPort, Port: port}); err != nil {
+			return err
+		}
+	}
+}
+
+// Starts a garbler for the service on a free port, and returns this port once the garbler listens on it
+func (cs *ControlServer) startGarbler(ctx context.Context, garblers *sync.WaitGroup, svc service) (int, error) {
+	cs.mu.RLock()
+	pm := cs.ports
+	cs.mu.RUnlock()
+
+	var port int
+	var err error
+	if pm != nil {
+		port, err = pm.Reserve()
+	} else {
+		port, err = freePort()
+	}
+	if err != nil {
+		return 0, err
+	}
+	release := func() {
+		if pm != nil {
+			pm.Release(port)
+		}
+	}
+
+	g, err := svc.session.StartServer(ctx, svc.input, port)
+	if err != nil {
+		release()
+		return 0, err
+	}
+	garblers.Add(1)
+	go func() {
+		defer garblers.Done()
+		defer release()
+		if err := g.Wait(); err != nil && ctx.Err() == nil {
+			fmt.Printf("\tGarbler on port %d failed: %v\n", port, err)
+		}
+	}()
+	return port, nil
+}
+
+// Connects the streams Bob opens in the tunnel to the garblers listening on our loopback, until the tunnel is closed
+func acceptStreams(ctx context.Context, m *mux, ports *sync.Map) {
+	var forwards sync.WaitGroup
+	defer forwards.Wait()
+	for {
+		select {
+		case s := <-m.accept:
+			if _, ok := ports.LoadAndDelete(s.target); !ok {
+				s.Close()
+				continue
+			}
+			forwards.Add(1)
+			go func() {
+				defer forwards.Done()
+				forward(ctx, s, "127.0.0.1")
+			}()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// Connects the stream to the garbler listening on host, on the stream's target port
+func forward(ctx context.Context, s *muxStream, host string) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(s.target)))
+	if err != nil {
+		s.Close()
+		return
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+		s.Close()
+	})
+	defer stop()
+	pipe(conn.(*net.TCPConn), s)
+}
+
+// Asks the system for a port nobody is using
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrPortUnavailable, err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Conn is Bob's side of a control session with Alice's ControlServer. Each evaluation asks Alice for a garbler and
+// evaluates the session's circuit against it. A Conn is safe for concurrent use, but the evaluations are done one at
+// a time. The modes of operation run over it by taking its Block, e.g. CBCEncrypt(conn.Block(ctx), plaintext, iv).
+type Conn struct {
+	session *Session
+	host    string
+	mu      sync.Mutex
+	ch      *controlConn
+	// Set when the garblers are reached through the tunnel
+	mux *mux
+}
+
+// Dial opens a control session with Alice's ControlServer listening on addr, asking for the given circuit name. Bob
+// announces how many evaluations he will need, 0 meaning as many as he wants until Close. The context is only used
+// for opening the session.
+func (s *Session) Dial(ctx context.Context, addr string, circuit string, blocks int) (*Conn, error) {
+	return s.dial(ctx, addr, circuit, blocks, false)
+}
+
+// DialTunnel works as Dial, but every evaluation goes through the control connection, so that the control port is the
+// only port of Alice which has to be reachable. This is what to use when Alice is behind a firewall.
+func (s *Session) DialTunnel(ctx context.Context, addr string, circuit string, blocks int) (*Conn, error) {
+	return s.dial(ctx, addr, circuit, blocks, true)
+}
+
+func (s *Session) dial(ctx context.Context, addr string, circuit string, blocks int, tunnel bool) (*Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{session: s, host: host, ch: newControlConn(conn)}
+	err = c.roundTrip(ctx, controlMessage{Type: msgOpen, Circuit: circuit, Blocks: blocks, Tunnel: tunnel}, msgOK, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if tunnel {
+		c.mux = c.ch.tunnel(false)
+	}
+	return c, nil
+}
+
+// Sends a message to Alice and waits for her answer, interrupting the exchange if the context is done
+func (c *Conn) roundTrip(ctx context.Context, m controlMessage, want string, answer *controlMessage) error {
+	stop := context.AfterFunc(ctx, func() { c.ch.conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	err := c.ch.write(m)
+	var a controlMessage
+	if err == nil {
+		a, err = c.ch.read()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// The connection is in an unknown state now
+			c.ch.conn.Close()
+			return ctx.Err()
+		}
+		return err
+	}
+	if a.Type == msgError {
+		return errors.New("tinylib: Alice: " + a.Error)
+	}
+	if a.Type != want {
+		return fmt.Errorf("tinylib: expected a %q message from Alice, got %q", want, a.Type)
+	}
+	if answer != nil {
+		*answer = a
+	}
+	return nil
+}
+
+// Client asks Alice for a garbler and evaluates the circuit with Bob's data against it, returning the raw output.
+func (c *Conn) Client(ctx context.Context, data string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var answer controlMessage
+	if err := c.roundTrip(ctx, controlMessage{Type: msgNext}, msgPort, &answer); err != nil {
+		return "", err
+	}
+	if c.mux == nil {
+		return c.session.Client(ctx, data, c.host, answer.Port)
+	}
+	return c.tunnelClient(ctx, data, answer.Port)
+}
+
+// Evaluates the circuit through the tunnel: Bob's evaluator connects to a listener on our loopback, whose connection
+// is carried by a new stream to Alice's garbler on the given port
+func (c *Conn) tunnelClient(ctx context.Context, data string, port int) (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		s, err := c.mux.open(port)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		stopConn := context.AfterFunc(ctx, func() {
+			conn.Close()
+			s.Close()
+		})
+		defer stopConn()
+		pipe(conn.(*net.TCPConn), s)
+	}()
+
+	out, err := c.session.Client(ctx, data, "127.0.0.1", l.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		// The evaluator may have failed before even connecting
+		cancel()
+	}
+	<-forwarded
+	return out, err
+}
+
+func (c *Conn) evaluate(ctx context.Context, data string) (string, error) {
+	return c.Client(ctx, data)
+}
+
+// Close tells Alice that Bob is done, so she can tear the session down, and closes the connection.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.ch.write(controlMessage{Type: msgDone})
+	if cerr := c.ch.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// The number of blocks of 128 bits in the given hexadecimal data, which is what Bob announces to Alice for AESCBC and AESCTR
+func BlockCount(data string) int {
+	return (len(data) + 31) / 32
+}
+
+// The number of blocks to announce for AESCBC with a padding, which always adds a block when the data is a whole number
+// of blocks
+func PaddedBlockCount(data string) int {
+	return len(data)/32 + 1
+}
@@ -0,0 +1,282 @@
+package tinylib
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Starts a ControlServer offering the AES circuit with the given key on a free port of localhost, and returns its address
+func startControlServer(t *testing.T, s *Session, key string) string {
+	cs := NewControlServer()
+	cs.Handle("aes_1cc.scd", s, ReverseEndianness(key))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	startServer(t, func(ctx context.Context) error {
+		return cs.Serve(ctx, l)
+	})
+	return l.Addr().String()
+}
+
+// The same test vectors as TestAESServ and TestAESCBC, with Bob asking for the ports over the control channel
+func TestControlModes(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	ctx := context.Background()
+
+	addr := startControlServer(t, s, "2b7e151628aed2a6abf7158809cf4f3c")
+	data := "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710"
+	c, err := s.Dial(ctx, addr, "aes_1cc.scd", BlockCount(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ans, err := CTREncrypt(c.Block(ctx), mustHex(t, data), [BlockSize]byte(mustHex(t, "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	awaitedResult := "874d6191b620e3261bef6864990db6ce9806f66b7970fdff8617187bb9fffdff5ae4df3edbd5d35e5b4f09020db03eab1e031dda2fbe03d1792170a0f3009cee"
+	if hex.EncodeToString(ans) != awaitedResult {
+		t.Errorf("Expected %s, got %x", awaitedResult, ans)
+	}
+	// All the announced blocks have been used
+	if _, err = c.Client(ctx, "00000000000000000000000000000000"); err == nil || !strings.Contains(err.Error(), "announced") {
+		t.Error("Expected an error once all the blocks are used, got", err)
+	}
+	if err = c.Close(); err != nil {
+		t.Error(err)
+	}
+
+	addr = startControlServer(t, s, "636869636b656e207465726979616b69")
+	c, err = s.Dial(ctx, addr, "aes_1cc.scd", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ans, err = CBCEncrypt(c.Block(ctx), mustHex(t, "4920776f756c64206c696b65207468652047656e6572616c20476175277320436869636b656e2c20706c656173652c"), [BlockSize]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	awaitedResult = "97687268d6ecccc0c07b25e25ecfe584b3fffd940c16a18c1b5549d2f838029e39312523a78662d5be7fcbcc98ebf5"
+	if hex.EncodeToString(ans) != awaitedResult {
+		t.Errorf("Expected %s, got %x", awaitedResult, ans)
+	}
+}
+
+func TestControlUnknownCircuit(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	addr := startControlServer(t, s, "2b7e151628aed2a6abf7158809cf4f3c")
+
+	_, err := s.Dial(context.Background(), addr, "sha3_24cc.scd", 1)
+	if err == nil || !strings.Contains(err.Error(), "unknown circuit") {
+		t.Error("Expected an unknown circuit error, got", err)
+	}
+}
+
+// A client sending an endless line gets the session closed instead of Alice buffering it
+func TestControlLongLine(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	addr := startControlServer(t, s, "2b7e151628aed2a6abf7158809cf4f3c")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go conn.Write(bytes.Repeat([]byte("a"), 2*maxControlLine))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// Alice closing with our data unread may reset the connection, only a timeout means she kept reading
+	if _, err := io.ReadAll(conn); errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Error("Expected Alice to close the connection, got", err)
+	}
+}
+
+// Bob leaving without using his garbler must not leave it running on Alice's side: the test cleanup waits for it
+func TestControlTeardown(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	addr := startControlServer(t, s, "2b7e151628aed2a6abf7158809cf4f3c")
+
+	c, err := s.Dial(context.Background(), addr, "aes_1cc.scd", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var answer controlMessage
+	if err = c.roundTrip(context.Background(), controlMessage{Type: msgNext}, msgPort, &answer); err != nil {
+		t.Fatal(err)
+	}
+	if listen, ok := listening(answer.Port); ok && !listen {
+		t.Error("Expected the garbler to listen on port", answer.Port)
+	}
+	if err = c.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// The same vectors again, with every evaluation going through the control connection
+func TestControlTunnel(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	ctx := context.Background()
+
+	addr := startControlServer(t, s, "2b7e151628aed2a6abf7158809cf4f3c")
+	data := "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710"
+	c, err := s.DialTunnel(ctx, addr, "aes_1cc.scd", BlockCount(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ans, err := CTREncrypt(c.Block(ctx), mustHex(t, data), [BlockSize]byte(mustHex(t, "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	awaitedResult := "874d6191b620e3261bef6864990db6ce9806f66b7970fdff8617187bb9fffdff5ae4df3edbd5d35e5b4f09020db03eab1e031dda2fbe03d1792170a0f3009cee"
+	if hex.EncodeToString(ans) != awaitedResult {
+		t.Errorf("Expected %s, got %x", awaitedResult, ans)
+	}
+
+	// Bob can't use the tunnel to reach a port Alice didn't give him
+	st, err := c.mux.open(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := st.Read(make([]byte, 1)); n != 0 || err == nil {
+		t.Error("Expected the stream to be closed, got", n, err)
+	}
+}
+
+// With UsePorts, the garblers only listen on the ports of the PortManager, and Bob gets an error once they are exhausted
+func TestControlPorts(t *testing.T) {
+	s := testSession(t, "hamming_32bit_1cc.scd")
+	ctx := context.Background()
+	pm, first := testPortManager(t, 1, DefaultQuarantine)
+
+	cs := NewControlServer()
+	cs.Handle("hamming", s, "FF55AA77")
+	cs.UsePorts(pm)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	startServer(t, func(ctx context.Context) error {
+		return cs.Serve(ctx, l)
+	})
+
+	c, err := s.Dial(ctx, l.Addr().String(), "hamming", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ans, err := c.Client(ctx, "12345678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ans != "13\n" {
+		t.Error("Expected 13, got", ans)
+	}
+	// The only port is now quarantined
+	if _, err := c.Client(ctx, "12345678"); err == nil {
+		t.Error("Expected an error once the ports are exhausted")
+	}
+	if _, err := pm.Reserve(); !errors.Is(err, ErrPortsExhausted) {
+		t.Error("Expected port", first, "to be quarantined, got", err)
+	}
+}
+
+// A GarbledBlock over the control session gets a garbler for each block, however many the standard library asks for
+func TestControlBlock(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	ctx := context.Background()
+	addr := startControlServer(t, s, "00000000000000000000000000000000")
+	c, err := s.Dial(ctx, addr, "aes_1cc.scd", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	gcm, err := cipher.NewGCM(c.Block(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := gcm.Seal(nil, make([]byte, 12), make([]byte, 16), nil)
+	if hex.EncodeToString(sealed) != "0388dace60b6a392f328c2b971b2fe78ab6e47d42cec13bdf53a67b21257bddf" {
+		t.Errorf("Unexpected GCM output %x", sealed)
+	}
+}
+
+// What CBCEncrypt encrypts over a control session, CBCDecrypt decrypts over another one with the decryption circuit
+func TestControlCBCDecrypt(t *testing.T) {
+	enc := testSession(t, "aes_1cc.scd")
+	dec := testSession(t, "aes_dec_1cc.scd")
+	ctx := context.Background()
+
+	key := ReverseEndianness("2b7e151628aed2a6abf7158809cf4f3c")
+	cs := NewControlServer()
+	cs.Handle("aes_1cc.scd", enc, key)
+	cs.Handle("aes_dec_1cc.scd", dec, key)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	startServer(t, func(ctx context.Context) error {
+		return cs.Serve(ctx, l)
+	})
+
+	data := "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445"
+	plaintext := mustHex(t, data)
+	iv := [BlockSize]byte(mustHex(t, "000102030405060708090a0b0c0d0e0f"))
+	ce, err := enc.Dial(ctx, l.Addr().String(), "aes_1cc.scd", BlockCount(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ce.Close()
+	ct, err := CBCEncrypt(ce.Block(ctx), plaintext, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cd, err := dec.Dial(ctx, l.Addr().String(), "aes_dec_1cc.scd", BlockCount(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cd.Close()
+	pt, err := CBCDecrypt(cd.Block(ctx), ct, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pt, plaintext) {
+		t.Errorf("Expected %s, got %x", data, pt)
+	}
+}
+
+// GCM over a control session, the hash subkey and the tag mask being two more blocks
+func TestControlGCM(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	ctx := context.Background()
+	addr := startControlServer(t, s, "feffe9928665731c6d6a8f9467308308")
+	nonce := mustHex(t, "cafebabefacedbaddecaf888")
+	pt := []byte("sealed with a key Bob never sees")
+
+	c, err := s.Dial(ctx, addr, "aes_1cc.scd", 2*(len(pt)/BlockSize+2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sealed, err := GCMSeal(c.Block(ctx), nonce, pt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := GCMOpen(c.Block(ctx), nonce, sealed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, pt) {
+		t.Errorf("Expected %q, got %q", pt, opened)
+	}
+}
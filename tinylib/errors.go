@@ -0,0 +1,57 @@
+package tinylib
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The errors returned by the library, so that callers can tell them apart using errors.Is
+var (
+	// ErrInvalidHex is returned when some data which should be an hexadecimal string isn't one
+	ErrInvalidHex = errors.New("tinylib: invalid hexadecimal data")
+	// ErrDataTooShort is returned when there isn't enough data for the requested operation, e.g. less than 128 bits in CBC mode
+	ErrDataTooShort = errors.New("tinylib: not enough data")
+	// ErrTinyGarbleFailed is matched by every *TinyGarbleError, use errors.As to get the exit code
+	ErrTinyGarbleFailed = errors.New("tinylib: TinyGarble failed")
+	// ErrInputFileUnsupported is returned when the TinyGarble executable doesn't have the --input_file flag, see
+	// TinyGarbleBackend
+	ErrInputFileUnsupported = errors.New("tinylib: TinyGarble doesn't support --input_file, use a build which does or set ArgvInput")
+	// ErrPortUnavailable is returned when a server can't listen on the port it was given
+	ErrPortUnavailable = errors.New("tinylib: port unavailable")
+	// ErrPortsExhausted is returned when a PortManager has no free port left in its range
+	ErrPortsExhausted = errors.New("tinylib: no free port left")
+	// ErrCounterExhausted is returned when the counter of the CTR mode would wrap around, see CounterLayout
+	ErrCounterExhausted = errors.New("tinylib: CTR counter exhausted")
+	// ErrInvalidPadding is returned when the padding of a decrypted CBC message is wrong. Beware of telling this error
+	// apart from the others to whoever sent the message, this would make a padding oracle.
+	ErrInvalidPadding = errors.New("tinylib: invalid padding")
+	// ErrAuthFailed is returned when a GCM or SIV ciphertext, its nonce or its additional data don't match its tag, or
+	// when the integrity check of a wrapped key fails
+	ErrAuthFailed = errors.New("tinylib: message authentication failed")
+	// ErrConnectFailed is returned when the client couldn't reach the server, which is worth trying again a bit later
+	ErrConnectFailed = errors.New("tinylib: could not connect to the server")
+)
+
+// TinyGarbleError is returned when the TinyGarble process couldn't be run or exited with a non zero status.
+// ExitCode is -1 if the process didn't even start or was killed by a signal.
+type TinyGarbleError struct {
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+func (e *TinyGarbleError) Error() string {
+	if e.ExitCode < 0 {
+		return fmt.Sprintf("tinylib: TinyGarble failed: %v", e.Err)
+	}
+	return fmt.Sprintf("tinylib: TinyGarble failed with exit status %d", e.ExitCode)
+}
+
+func (e *TinyGarbleError) Unwrap() error {
+	return e.Err
+}
+
+// Is allows errors.Is(err, ErrTinyGarbleFailed) to match any TinyGarbleError
+func (e *TinyGarbleError) Is(target error) bool {
+	return target == ErrTinyGarbleFailed
+}
@@ -0,0 +1,193 @@
+package tinylib
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+)
+
+// The modes feeding the output of the block cipher back into its input, which only need the AES encryption circuit,
+// for the decryption as well as for the encryption
+type feedbackMode int
+
+const (
+	// CFB with 128 bits segments, the ciphertext block being the next input
+	modeCFB feedbackMode = iota
+	// CFB with 8 bits segments, the input being shifted by one byte of ciphertext for each byte, so one evaluation per byte
+	modeCFB8
+	// OFB, the output of the block cipher being its next input
+	modeOFB
+)
+
+func (m feedbackMode) String() string {
+	switch m {
+	case modeCFB:
+		return "CFB"
+	case modeCFB8:
+		return "CFB8"
+	}
+	return "OFB"
+}
+
+// CFBEncrypt encrypts the plaintext, of any length, in CFB mode with 128 bits segments (CFB128), each block being one
+// evaluation of the garbled block against Alice. It works with Session.Block as well as with Conn.Block, so that the
+// garblers come from the next ports or from Alice's control server.
+func CFBEncrypt(b *GarbledBlock, plaintext []byte, iv [BlockSize]byte) ([]byte, error) {
+	return feedbackCrypt(b, modeCFB, plaintext, iv, false)
+}
+
+// CFBDecrypt decrypts the output of CFBEncrypt. CFB decryption uses the AES encryption circuit too.
+func CFBDecrypt(b *GarbledBlock, ciphertext []byte, iv [BlockSize]byte) ([]byte, error) {
+	return feedbackCrypt(b, modeCFB, ciphertext, iv, true)
+}
+
+// CFB8Encrypt works as CFBEncrypt with 8 bits segments (CFB8): beware that each byte, rather than each block, is one
+// evaluation.
+func CFB8Encrypt(b *GarbledBlock, plaintext []byte, iv [BlockSize]byte) ([]byte, error) {
+	return feedbackCrypt(b, modeCFB8, plaintext, iv, false)
+}
+
+// CFB8Decrypt decrypts the output of CFB8Encrypt, with one evaluation per byte as well.
+func CFB8Decrypt(b *GarbledBlock, ciphertext []byte, iv [BlockSize]byte) ([]byte, error) {
+	return feedbackCrypt(b, modeCFB8, ciphertext, iv, true)
+}
+
+// OFBEncrypt encrypts the plaintext, of any length, in OFB mode. As for CTR, never reuse an IV with the same key, the
+// keystream would be the same.
+func OFBEncrypt(b *GarbledBlock, plaintext []byte, iv [BlockSize]byte) ([]byte, error) {
+	return feedbackCrypt(b, modeOFB, plaintext, iv, false)
+}
+
+// OFBDecrypt decrypts the output of OFBEncrypt, which is the same as encrypting it again.
+func OFBDecrypt(b *GarbledBlock, ciphertext []byte, iv [BlockSize]byte) ([]byte, error) {
+	return feedbackCrypt(b, modeOFB, ciphertext, iv, true)
+}
+
+// The feedback modes themselves, each block cipher call being an evaluation of the garbled block. The output is as
+// long as the input, none of these modes needs a padding.
+func feedbackCrypt(b *GarbledBlock, mode feedbackMode, src []byte, iv [BlockSize]byte, decrypt bool) ([]byte, error) {
+	fmt.Println("\tAES", mode, "started")
+	dst := make([]byte, len(src))
+	register := iv
+	var keystream [BlockSize]byte
+	defer clear(keystream[:])
+	defer clear(register[:])
+
+	segment := BlockSize
+	if mode == modeCFB8 {
+		segment = 1
+	}
+	for i := 0; i < len(src); i += segment {
+		if err := b.EncryptBlock(keystream[:], register[:]); err != nil {
+			return nil, err
+		}
+		end := min(i+segment, len(src))
+		subtle.XORBytes(dst[i:end], src[i:end], keystream[:end-i])
+		// The ciphertext goes back into the register for CFB, whether we encrypt or decrypt
+		ciphertext := dst[i:end]
+		if decrypt {
+			ciphertext = src[i:end]
+		}
+		switch mode {
+		case modeCFB:
+			copy(register[:], ciphertext)
+		case modeCFB8:
+			copy(register[:], register[1:])
+			register[BlockSize-1] = ciphertext[0]
+		case modeOFB:
+			register = keystream
+		}
+	}
+	return dst, nil
+}
+
+// The hexadecimal layer of the feedback modes, with the same IV handling as AESCBC and AESCTR
+func aesFeedback(b *GarbledBlock, mode feedbackMode, data string, o_iv ...string) ([]string, string, error) {
+	plaintext, iv, err := hexModeInput(data, o_iv...)
+	if err != nil {
+		return nil, "", err
+	}
+	cipher, err := feedbackCrypt(b, mode, plaintext, iv, false)
+	if err != nil {
+		return nil, "", err
+	}
+	return hexBlocks(cipher), hex.EncodeToString(iv[:]), nil
+}
+
+// The hexadecimal layer of the feedback modes decryption
+func aesFeedbackDecrypt(b *GarbledBlock, mode feedbackMode, data string, iv string) ([]string, error) {
+	ciphertext, ivBytes, err := hexDecryptInput(data, iv)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := feedbackCrypt(b, mode, ciphertext, ivBytes, true)
+	if err != nil {
+		return nil, err
+	}
+	return hexBlocks(plain), nil
+}
+
+// AESCFB encrypts the hexadecimal data, of any length, in CFB mode with 128 bits segments (CFB128), using the session's
+// AES circuit on the consecutive ports starting at port, one per block. As for AESCBC, a random IV is used unless
+// a 128 bits one is given, and the ciphertext is returned in blocks of 128 bits, along with the IV.
+func (s *Session) AESCFB(ctx context.Context, data string, addr string, port int, o_iv ...string) ([]string, string, error) {
+	return aesFeedback(s.Block(ctx, addr, port), modeCFB, data, o_iv...)
+}
+
+// AESCFBDecrypt decrypts the output of AESCFB, given as one hexadecimal string, with the IV it returned. CFB decryption
+// uses the AES encryption circuit too.
+func (s *Session) AESCFBDecrypt(ctx context.Context, data string, addr string, port int, iv string) ([]string, error) {
+	return aesFeedbackDecrypt(s.Block(ctx, addr, port), modeCFB, data, iv)
+}
+
+// AESCFB8 works as AESCFB with 8 bits segments (CFB8): beware that each byte, rather than each block, uses one port.
+func (s *Session) AESCFB8(ctx context.Context, data string, addr string, port int, o_iv ...string) ([]string, string, error) {
+	return aesFeedback(s.Block(ctx, addr, port), modeCFB8, data, o_iv...)
+}
+
+// AESCFB8Decrypt decrypts the output of AESCFB8, using one port per byte as well.
+func (s *Session) AESCFB8Decrypt(ctx context.Context, data string, addr string, port int, iv string) ([]string, error) {
+	return aesFeedbackDecrypt(s.Block(ctx, addr, port), modeCFB8, data, iv)
+}
+
+// AESOFB encrypts the hexadecimal data, of any length, in OFB mode, as AESCFB does in CFB mode. As for CTR, never
+// reuse an IV with the same key, the keystream would be the same.
+func (s *Session) AESOFB(ctx context.Context, data string, addr string, port int, o_iv ...string) ([]string, string, error) {
+	return aesFeedback(s.Block(ctx, addr, port), modeOFB, data, o_iv...)
+}
+
+// AESOFBDecrypt decrypts the output of AESOFB, which is the same as encrypting it again.
+func (s *Session) AESOFBDecrypt(ctx context.Context, data string, addr string, port int, iv string) ([]string, error) {
+	return aesFeedbackDecrypt(s.Block(ctx, addr, port), modeOFB, data, iv)
+}
+
+// EncryptCFB works as AESCFB with bytes.
+func (s *Session) EncryptCFB(ctx context.Context, plaintext []byte, iv [BlockSize]byte, addr string, port int) ([]byte, error) {
+	return CFBEncrypt(s.Block(ctx, addr, port), plaintext, iv)
+}
+
+// DecryptCFB works as AESCFBDecrypt with bytes.
+func (s *Session) DecryptCFB(ctx context.Context, ciphertext []byte, iv [BlockSize]byte, addr string, port int) ([]byte, error) {
+	return CFBDecrypt(s.Block(ctx, addr, port), ciphertext, iv)
+}
+
+// EncryptCFB8 works as AESCFB8 with bytes.
+func (s *Session) EncryptCFB8(ctx context.Context, plaintext []byte, iv [BlockSize]byte, addr string, port int) ([]byte, error) {
+	return CFB8Encrypt(s.Block(ctx, addr, port), plaintext, iv)
+}
+
+// DecryptCFB8 works as AESCFB8Decrypt with bytes.
+func (s *Session) DecryptCFB8(ctx context.Context, ciphertext []byte, iv [BlockSize]byte, addr string, port int) ([]byte, error) {
+	return CFB8Decrypt(s.Block(ctx, addr, port), ciphertext, iv)
+}
+
+// EncryptOFB works as AESOFB with bytes.
+func (s *Session) EncryptOFB(ctx context.Context, plaintext []byte, iv [BlockSize]byte, addr string, port int) ([]byte, error) {
+	return OFBEncrypt(s.Block(ctx, addr, port), plaintext, iv)
+}
+
+// DecryptOFB works as AESOFBDecrypt with bytes.
+func (s *Session) DecryptOFB(ctx context.Context, ciphertext []byte, iv [BlockSize]byte, addr string, port int) ([]byte, error) {
+	return OFBDecrypt(s.Block(ctx, addr, port), ciphertext, iv)
+}
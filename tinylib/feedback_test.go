@@ -0,0 +1,122 @@
+package tinylib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// The SP 800-38A vectors of F.3.13, F.3.7 and F.4.1, which share the key, the IV and the plaintext
+const (
+	sp80038aKey   = "2b7e151628aed2a6abf7158809cf4f3c"
+	sp80038aIV    = "000102030405060708090a0b0c0d0e0f"
+	sp80038aPlain = "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710"
+)
+
+var feedbackVectors = []struct {
+	mode   feedbackMode
+	cipher string
+}{
+	{modeCFB, "3b3fd92eb72dad20333449f8e83cfb4ac8a64537a0b3a93fcde3cdad9f1ce58b26751f67a3cbb140b1808cf187a4f4dfc04b05357c5d1c0eeac4c66f9ff7f2e6"},
+	{modeCFB8, "3b79424c9c0dd436bace9e0ed4586a4f32b9"},
+	{modeOFB, "3b3fd92eb72dad20333449f8e83cfb4a7789508d16918f03f53c52dac54ed8259740051e9c5fecf64344f7a82260edcc304c6528f659c77866a510d9c1d6ae5e"},
+}
+
+// Each mode gives its vectors, also cut in the middle of a block, and decrypts them back
+func TestFeedbackModes(t *testing.T) {
+	p := newCountingPeer(t, sp80038aKey)
+	b := &GarbledBlock{ctx: context.Background(), p: p}
+	iv := [BlockSize]byte(mustHex(t, sp80038aIV))
+	plain := mustHex(t, sp80038aPlain)
+	for _, v := range feedbackVectors {
+		expected := mustHex(t, v.cipher)
+		for _, n := range []int{len(expected), len(expected) - 5} {
+			p.calls = 0
+			ct, err := feedbackCrypt(b, v.mode, plain[:n], iv, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(ct, expected[:n]) {
+				t.Errorf("%v, %d bytes: expected %x, got %x", v.mode, n, expected[:n], ct)
+			}
+			evaluations := (n + BlockSize - 1) / BlockSize
+			if v.mode == modeCFB8 {
+				evaluations = n
+			}
+			if p.calls != evaluations {
+				t.Errorf("%v, %d bytes: expected %d evaluations, got %d", v.mode, n, evaluations, p.calls)
+			}
+			pt, err := feedbackCrypt(b, v.mode, ct, iv, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(pt, plain[:n]) {
+				t.Errorf("%v, %d bytes: expected %x, got %x", v.mode, n, plain[:n], pt)
+			}
+		}
+	}
+}
+
+// The hexadecimal API through the garblers, with the IV given or generated
+func TestAESCFB(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	ctx := context.Background()
+	cipher, iv, err := s.AESCFB(ctx, sp80038aPlain, "127.0.0.1", startAES(t, s, sp80038aKey, 4), sp80038aIV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iv != sp80038aIV || strings.Join(cipher, "") != strings.ToUpper(feedbackVectors[0].cipher) {
+		t.Error("Expected", feedbackVectors[0].cipher, "with", sp80038aIV, "got", cipher, "with", iv)
+	}
+
+	data := "00112233445566778899aabbccddeeff0011"
+	cipher, iv, err = s.AESOFB(ctx, data, "127.0.0.1", startAES(t, s, sp80038aKey, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iv == sp80038aIV || len(iv) != 32 {
+		t.Error("Expected a random IV, got", iv)
+	}
+	plain, err := s.AESOFBDecrypt(ctx, strings.Join(cipher, ""), "127.0.0.1", startAES(t, s, sp80038aKey, 2), iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(plain, "") != strings.ToUpper(data) {
+		t.Error("Expected", data, "got", plain)
+	}
+
+	if _, err := s.AESCFB8Decrypt(ctx, data, "127.0.0.1", 1, "0011"); !errors.Is(err, ErrInvalidHex) {
+		t.Error("Expected ErrInvalidHex for a short iv, got", err)
+	}
+}
+
+// Over a control session, the free functions take the Conn's block
+func TestControlFeedback(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	ctx := context.Background()
+	addr := startControlServer(t, s, sp80038aKey)
+	plain := mustHex(t, sp80038aPlain)
+	c, err := s.Dial(ctx, addr, "aes_1cc.scd", 2*BlockCount(sp80038aPlain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	iv := [BlockSize]byte(mustHex(t, sp80038aIV))
+	ct, err := OFBEncrypt(c.Block(ctx), plain, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := mustHex(t, feedbackVectors[2].cipher); !bytes.Equal(ct, expected) {
+		t.Errorf("Expected %x, got %x", expected, ct)
+	}
+	pt, err := CFBDecrypt(c.Block(ctx), mustHex(t, feedbackVectors[0].cipher), iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pt, plain) {
+		t.Errorf("Expected %x, got %x", plain, pt)
+	}
+}
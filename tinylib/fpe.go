@@ -0,0 +1,293 @@
+package tinylib
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// The numerals of the format preserving encryption, as strconv writes them: the radix first ones of digits then
+// lowercase letters
+const fpeAlphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// FF1Encrypt encrypts the numeral string x in base radix with FF1 (NIST SP 800-38G), so that the ciphertext has the
+// same length and alphabet as x, e.g. to tokenize card numbers with radix 10. Each round function is a CBC-MAC, and
+// every AES call is an evaluation of the garbled block against Alice: she never learns x, and Bob never learns the key.
+// The radix goes from 2 to 36, the numerals being the digits then the lowercase letters, and x has to have at least
+// 2 numerals, and at least a million possible values. The tweak may be of any length, even empty.
+//
+// FF1BlockCount gives the number of garblers used.
+func FF1Encrypt(b *GarbledBlock, radix int, tweak []byte, x string) (string, error) {
+	fmt.Println("\tAES FF1 started")
+	return ff1(b, radix, tweak, x, false)
+}
+
+// FF1Decrypt decrypts a numeral string encrypted by FF1Encrypt with the same radix and tweak. It uses the AES
+// encryption circuit too.
+func FF1Decrypt(b *GarbledBlock, radix int, tweak []byte, x string) (string, error) {
+	fmt.Println("\tAES FF1 decryption started")
+	return ff1(b, radix, tweak, x, true)
+}
+
+// FF31Encrypt encrypts the numeral string x in base radix with FF3-1 (NIST SP 800-38G revision 1), with the same
+// numerals as FF1Encrypt. The tweak is 7 bytes long, and x can't be longer than twice the number of numerals fitting
+// in 96 bits, e.g. 56 digits. FF3-1 uses AES with the bytes of the key reversed: Alice has to garble the circuit with
+// the key given by FF3Key. Each of the 8 rounds uses one garbler.
+func FF31Encrypt(b *GarbledBlock, radix int, tweak []byte, x string) (string, error) {
+	fmt.Println("\tAES FF3-1 started")
+	return ff31(b, radix, tweak, x, false)
+}
+
+// FF31Decrypt decrypts a numeral string encrypted by FF31Encrypt with the same radix and tweak, using the same AES
+// encryption circuit.
+func FF31Decrypt(b *GarbledBlock, radix int, tweak []byte, x string) (string, error) {
+	fmt.Println("\tAES FF3-1 decryption started")
+	return ff31(b, radix, tweak, x, true)
+}
+
+// AESFF1Encrypt works as FF1Encrypt, with the session's AES encryption circuit garbled by Alice on the consecutive ports
+// starting at port, as many as FF1BlockCount tells.
+func (s *Session) AESFF1Encrypt(ctx context.Context, x string, radix int, tweak []byte, addr string, port int) (string, error) {
+	return FF1Encrypt(s.Block(ctx, addr, port), radix, tweak, x)
+}
+
+// AESFF1Decrypt works as FF1Decrypt, with the ports of AESFF1Encrypt.
+func (s *Session) AESFF1Decrypt(ctx context.Context, x string, radix int, tweak []byte, addr string, port int) (string, error) {
+	return FF1Decrypt(s.Block(ctx, addr, port), radix, tweak, x)
+}
+
+// AESFF31Encrypt works as FF31Encrypt, with the session's AES encryption circuit garbled by Alice with FF3Key(key) on
+// the 8 consecutive ports starting at port.
+func (s *Session) AESFF31Encrypt(ctx context.Context, x string, radix int, tweak []byte, addr string, port int) (string, error) {
+	return FF31Encrypt(s.Block(ctx, addr, port), radix, tweak, x)
+}
+
+// AESFF31Decrypt works as FF31Decrypt, with the ports of AESFF31Encrypt.
+func (s *Session) AESFF31Decrypt(ctx context.Context, x string, radix int, tweak []byte, addr string, port int) (string, error) {
+	return FF31Decrypt(s.Block(ctx, addr, port), radix, tweak, x)
+}
+
+// FF1BlockCount is the number of AES blocks, and so of garblers, FF1 uses to encrypt or decrypt length numerals in base
+// radix with a tweak of tweakLength bytes, which is what Bob announces to Alice. Each of the 10 rounds has a CBC-MAC
+// over a block of parameters and the tweak, the round number and half of the numerals, padded to whole blocks, and then
+// one more block every 16 bytes of its output beyond the first.
+func FF1BlockCount(radix, tweakLength, length int) int {
+	bLen, d := ff1Sizes(radix, length)
+	mac := 1 + (tweakLength+1+bLen+BlockSize-1)/BlockSize
+	return 10 * (mac + (d+BlockSize-1)/BlockSize - 1)
+}
+
+// FF3Key returns the key Alice has to garble the AES circuit with for FF3-1, which is the FF3-1 key with its bytes
+// reversed.
+func FF3Key(key []byte) []byte {
+	rev := make([]byte, len(key))
+	reverseBytes(rev, key)
+	return rev
+}
+
+// Decodes the numeral string, checking that its numerals are in base radix and that there are at least minLen of them,
+// and at least a million possible values as SP 800-38G requires
+func fpeNumerals(radix int, x string, minLen int) ([]int, error) {
+	if radix < 2 || radix > len(fpeAlphabet) {
+		return nil, fmt.Errorf("tinylib: invalid radix %d, it goes from 2 to %d", radix, len(fpeAlphabet))
+	}
+	numerals := make([]int, len(x))
+	for i := 0; i < len(x); i++ {
+		n := strings.IndexByte(fpeAlphabet[:radix], x[i])
+		if n < 0 {
+			return nil, fmt.Errorf("tinylib: %q isn't a numeral in base %d", x[i], radix)
+		}
+		numerals[i] = n
+	}
+	if len(x) < minLen || pow(radix, len(x)).Cmp(big.NewInt(1000000)) < 0 {
+		return nil, fmt.Errorf("%w: %d numerals in base %d are too few for format preserving encryption", ErrDataTooShort, len(x), radix)
+	}
+	return numerals, nil
+}
+
+// Encodes the numerals back to a string
+func fpeString(numerals []int) string {
+	var sb strings.Builder
+	for _, n := range numerals {
+		sb.WriteByte(fpeAlphabet[n])
+	}
+	return sb.String()
+}
+
+// FF1 of SP 800-38G algorithms 7 and 8
+func ff1(b *GarbledBlock, radix int, tweak []byte, x string, decrypt bool) (string, error) {
+	numerals, err := fpeNumerals(radix, x, 2)
+	if err != nil {
+		return "", err
+	}
+	n, t := len(numerals), len(tweak)
+	u, v := n/2, n-n/2
+	a, c := numerals[:u], numerals[u:]
+	bLen, d := ff1Sizes(radix, n)
+
+	p := make([]byte, BlockSize)
+	p[0], p[1], p[2] = 1, 2, 1
+	p[3], p[4], p[5] = byte(radix>>16), byte(radix>>8), byte(radix)
+	p[6], p[7] = 10, byte(u)
+	binary.BigEndian.PutUint32(p[8:], uint32(n))
+	binary.BigEndian.PutUint32(p[12:], uint32(t))
+
+	zeros := ((-(t + bLen + 1))%BlockSize + BlockSize) % BlockSize
+	q := make([]byte, t+zeros+1+bLen)
+	copy(q, tweak)
+	s := make([]byte, (d+BlockSize-1)/BlockSize*BlockSize)
+	defer clear(s)
+	for r := 0; r < 10; r++ {
+		i := r
+		if decrypt {
+			i = 9 - r
+		}
+		// The round number and the half which isn't changed by this round
+		q[t+zeros] = byte(i)
+		half := c
+		if decrypt {
+			half = a
+		}
+		num(half, radix).FillBytes(q[t+zeros+1:])
+		mac, err := cbcMAC(b, p, q)
+		if err != nil {
+			return "", err
+		}
+		copy(s, mac[:])
+		for j := 1; j*BlockSize < d; j++ {
+			var block [BlockSize]byte
+			binary.BigEndian.PutUint64(block[8:], uint64(j))
+			for k := range block {
+				block[k] ^= mac[k]
+			}
+			if err := b.EncryptBlock(s[j*BlockSize:], block[:]); err != nil {
+				return "", err
+			}
+		}
+		y := new(big.Int).SetBytes(s[:d])
+
+		m := u
+		if i%2 == 1 {
+			m = v
+		}
+		if decrypt {
+			a, c = str(new(big.Int).Sub(num(c, radix), y), radix, m), a
+		} else {
+			a, c = c, str(new(big.Int).Add(num(a, radix), y), radix, m)
+		}
+	}
+	return fpeString(append(a, c...)), nil
+}
+
+// The bytes of the larger half of n numerals as an integer, and those of the FF1 round function output
+func ff1Sizes(radix, n int) (bLen, d int) {
+	bLen = (new(big.Int).Sub(pow(radix, n-n/2), big.NewInt(1)).BitLen() + 7) / 8
+	return bLen, 4*((bLen+3)/4) + 4
+}
+
+// FF3-1 of SP 800-38G revision 1 algorithms 9 and 10
+func ff31(b *GarbledBlock, radix int, tweak []byte, x string, decrypt bool) (string, error) {
+	if len(tweak) != 7 {
+		return "", fmt.Errorf("tinylib: the FF3-1 tweak is 56 bits long, got %d bits", 8*len(tweak))
+	}
+	numerals, err := fpeNumerals(radix, x, 2)
+	if err != nil {
+		return "", err
+	}
+	// The longest string is 2*floor(log_radix(2^96)), i.e. twice the number of numerals whose values fit in 96 bits
+	maxLen := 0
+	limit := new(big.Int).Lsh(big.NewInt(1), 96)
+	for pow(radix, maxLen+1).Cmp(limit) <= 0 {
+		maxLen++
+	}
+	if len(numerals) > 2*maxLen {
+		return "", fmt.Errorf("tinylib: FF3-1 can't encrypt more than %d numerals in base %d", 2*maxLen, radix)
+	}
+	var tl, tr [4]byte
+	copy(tl[:], tweak[:4])
+	tl[3] &= 0xf0
+	copy(tr[:], tweak[4:])
+	tr[3] = tweak[3] << 4
+	return ff3(b, radix, tl, tr, numerals, decrypt)
+}
+
+// The rounds of FF3, with the tweak already split in its two halves, which FF3-1 builds from a 56 bits tweak
+func ff3(b *GarbledBlock, radix int, tl, tr [4]byte, numerals []int, decrypt bool) (string, error) {
+	n := len(numerals)
+	u := (n + 1) / 2
+	v := n - u
+	a, c := numerals[:u], numerals[u:]
+	var p [BlockSize]byte
+	defer clear(p[:])
+	for r := 0; r < 8; r++ {
+		i := r
+		if decrypt {
+			i = 7 - r
+		}
+		m, w := u, tr
+		if i%2 == 1 {
+			m, w = v, tl
+		}
+		copy(p[:4], w[:])
+		p[3] ^= byte(i)
+		half := c
+		if decrypt {
+			half = a
+		}
+		num(reversed(half), radix).FillBytes(p[4:])
+
+		// S = REVB(CIPH_REVB(K)(REVB(P))), Alice's key being already reversed
+		reverseBytes(p[:], p[:])
+		if err := b.EncryptBlock(p[:], p[:]); err != nil {
+			return "", err
+		}
+		reverseBytes(p[:], p[:])
+		y := new(big.Int).SetBytes(p[:])
+
+		if decrypt {
+			a, c = reversed(str(new(big.Int).Sub(num(reversed(c), radix), y), radix, m)), a
+		} else {
+			a, c = c, reversed(str(new(big.Int).Add(num(reversed(a), radix), y), radix, m))
+		}
+	}
+	return fpeString(append(a, c...)), nil
+}
+
+// The integer whose numerals in base radix are given, the most significant first
+func num(numerals []int, radix int) *big.Int {
+	x := new(big.Int)
+	r := big.NewInt(int64(radix))
+	for _, n := range numerals {
+		x.Mul(x, r)
+		x.Add(x, big.NewInt(int64(n)))
+	}
+	return x
+}
+
+// The m numerals in base radix of x modulo radix^m, the most significant first
+func str(x *big.Int, radix int, m int) []int {
+	x = new(big.Int).Mod(x, pow(radix, m))
+	r := big.NewInt(int64(radix))
+	numerals := make([]int, m)
+	digit := new(big.Int)
+	for i := m - 1; i >= 0; i-- {
+		x.DivMod(x, r, digit)
+		numerals[i] = int(digit.Int64())
+	}
+	return numerals
+}
+
+func pow(radix int, m int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(int64(radix)), big.NewInt(int64(m)), nil)
+}
+
+// A reversed copy of the numerals
+func reversed(numerals []int) []int {
+	rev := make([]int, len(numerals))
+	for i, n := range numerals {
+		rev[len(numerals)-1-i] = n
+	}
+	return rev
+}
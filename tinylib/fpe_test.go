@@ -0,0 +1,221 @@
+package tinylib
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Samples 1, 2 and 3 of the NIST FF1 examples for AES-128
+var ff1Vectors = []struct {
+	radix  int
+	tweak  string
+	plain  string
+	cipher string
+}{
+	{10, "", "0123456789", "2433477484"},
+	{10, "39383736353433323130", "0123456789", "6124200773"},
+	{36, "3737373770717273373737", "0123456789abcdefghi", "a9tv40mll9kdu509eum"},
+}
+
+func TestFF1(t *testing.T) {
+	enc, _ := localBlocks(t, "2b7e151628aed2a6abf7158809cf4f3c", PadCTS)
+	for _, v := range ff1Vectors {
+		tweak := mustHex(t, v.tweak)
+		ct, err := FF1Encrypt(enc, v.radix, tweak, v.plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct != v.cipher {
+			t.Errorf("Radix %d, tweak %s: expected %s, got %s", v.radix, v.tweak, v.cipher, ct)
+		}
+		pt, err := FF1Decrypt(enc, v.radix, tweak, ct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pt != v.plain {
+			t.Errorf("Radix %d, tweak %s: expected %s, got %s", v.radix, v.tweak, v.plain, pt)
+		}
+	}
+}
+
+// The NIST FF3 examples, with their 64 bits tweaks, check the rounds shared with FF3-1
+func TestFF3(t *testing.T) {
+	enc, _ := localBlocks(t, hex.EncodeToString(FF3Key(mustHex(t, "ef4359d8d580aa4f7f036d6f04fc6a94"))), PadCTS)
+	for _, v := range []struct {
+		radix  int
+		tweak  string
+		plain  string
+		cipher string
+	}{
+		{10, "D8E7920AFA330A73", "890121234567890000", "750918814058654607"},
+		{10, "9A768A92F60E12D8", "890121234567890000", "018989839189395384"},
+		{10, "D8E7920AFA330A73", "89012123456789000000789000000", "48598367162252569629397416226"},
+		{26, "9A768A92F60E12D8", "0123456789abcdefghi", "g2pk40i992fn20cjakb"},
+	} {
+		tweak := mustHex(t, v.tweak)
+		tl, tr := [4]byte(tweak[:4]), [4]byte(tweak[4:])
+		numerals, err := fpeNumerals(v.radix, v.plain, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct, err := ff3(enc, v.radix, tl, tr, numerals, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct != v.cipher {
+			t.Errorf("Radix %d, tweak %s: expected %s, got %s", v.radix, v.tweak, v.cipher, ct)
+		}
+		numerals, _ = fpeNumerals(v.radix, ct, 2)
+		pt, err := ff3(enc, v.radix, tl, tr, numerals, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pt != v.plain {
+			t.Errorf("Radix %d, tweak %s: expected %s, got %s", v.radix, v.tweak, v.plain, pt)
+		}
+	}
+}
+
+func TestFF31(t *testing.T) {
+	key := mustHex(t, "ef4359d8d580aa4f7f036d6f04fc6a94")
+	enc, _ := localBlocks(t, hex.EncodeToString(FF3Key(key)), PadCTS)
+	tweak := mustHex(t, "d8e7920afa330a")
+	for _, v := range []struct {
+		radix int
+		plain string
+	}{
+		{10, "4111111111111111"},
+		{10, "890121234567890000"},
+		{10, "12345678901234567890123456789012345678901234567890123456"},
+		{36, "nationalid42"},
+		{2, "10110011101010010110"},
+	} {
+		ct, err := FF31Encrypt(enc, v.radix, tweak, v.plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ct) != len(v.plain) || ct == v.plain {
+			t.Errorf("Radix %d: %s encrypted to %s", v.radix, v.plain, ct)
+		}
+		if _, err := fpeNumerals(v.radix, ct, 2); err != nil {
+			t.Error(err)
+		}
+		pt, err := FF31Decrypt(enc, v.radix, tweak, ct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pt != v.plain {
+			t.Errorf("Radix %d: expected %s, got %s", v.radix, v.plain, pt)
+		}
+	}
+
+	// The 56 bits tweak is split around its fourth byte, whose halves go to each side
+	numerals, _ := fpeNumerals(10, "890121234567890000", 2)
+	split, err := ff3(enc, 10, [4]byte{0xd8, 0xe7, 0x92, 0x00}, [4]byte{0xfa, 0x33, 0x0a, 0xa0}, numerals, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := FF31Encrypt(enc, 10, tweak, "890121234567890000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct != split {
+		t.Errorf("Expected %s, got %s", split, ct)
+	}
+	other, err := FF31Encrypt(enc, 10, mustHex(t, "d8e7920afa330b"), "890121234567890000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == ct {
+		t.Error("The same ciphertext for two tweaks")
+	}
+}
+
+func TestFPEInvalid(t *testing.T) {
+	enc, _ := localBlocks(t, "2b7e151628aed2a6abf7158809cf4f3c", PadCTS)
+	if _, err := FF1Encrypt(enc, 10, nil, "12345"); !errors.Is(err, ErrDataTooShort) {
+		t.Error("Expected ErrDataTooShort, got", err)
+	}
+	if _, err := FF1Encrypt(enc, 2, nil, "1011"); !errors.Is(err, ErrDataTooShort) {
+		t.Error("Expected ErrDataTooShort, got", err)
+	}
+	for _, v := range []struct {
+		radix int
+		x     string
+	}{
+		{10, "01234567a9"},
+		{16, "0123456789ABCDEF"},
+		{1, "0000000000"},
+		{37, "0123456789"},
+	} {
+		if _, err := FF1Encrypt(enc, v.radix, nil, v.x); err == nil {
+			t.Errorf("Radix %d: expected an error for %s", v.radix, v.x)
+		}
+	}
+	if _, err := FF31Encrypt(enc, 10, make([]byte, 8), "0123456789"); err == nil {
+		t.Error("Expected an error for a 64 bits tweak")
+	}
+	if _, err := FF31Encrypt(enc, 10, make([]byte, 7), "123456789012345678901234567890123456789012345678901234567"); err == nil {
+		t.Error("Expected an error for 57 digits")
+	}
+}
+
+// The FF3-1 maxlen is 2*floor(log_radix(2^96)), which is exact for the powers of two
+func TestFF31MaxLength(t *testing.T) {
+	enc, _ := localBlocks(t, "ef4359d8d580aa4f7f036d6f04fc6a94", PadCTS)
+	for _, v := range []struct {
+		radix  int
+		maxLen int
+	}{
+		{2, 192},
+		{16, 48},
+		{10, 56},
+		{36, 36},
+	} {
+		x := strings.Repeat("1", v.maxLen)
+		ct, err := FF31Encrypt(enc, v.radix, make([]byte, 7), x)
+		if err != nil {
+			t.Errorf("Radix %d: expected %d numerals to work, got %v", v.radix, v.maxLen, err)
+			continue
+		}
+		if pt, err := FF31Decrypt(enc, v.radix, make([]byte, 7), ct); err != nil || pt != x {
+			t.Errorf("Radix %d: expected %s, got %s, %v", v.radix, x, pt, err)
+		}
+		if _, err := FF31Encrypt(enc, v.radix, make([]byte, 7), x+"1"); err == nil {
+			t.Errorf("Radix %d: expected an error for %d numerals", v.radix, v.maxLen+1)
+		}
+	}
+}
+
+// A card number tokenized through the garblers, each round getting its own port
+func TestAESFF1(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	key := "2b7e151628aed2a6abf7158809cf4f3c"
+	v := ff1Vectors[2]
+	tweak := mustHex(t, v.tweak)
+	blocks := FF1BlockCount(v.radix, len(tweak), len(v.plain))
+	if blocks != 30 {
+		t.Errorf("Expected 30 blocks, got %d", blocks)
+	}
+	ct, err := s.AESFF1Encrypt(context.Background(), v.plain, v.radix, tweak, "127.0.0.1", startAES(t, s, key, blocks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct != v.cipher {
+		t.Errorf("Expected %s, got %s", v.cipher, ct)
+	}
+
+	pt, err := s.AESFF31Decrypt(context.Background(), "4111111111111111", 10, make([]byte, 7), "127.0.0.1",
+		startAES(t, s, hex.EncodeToString(FF3Key(mustHex(t, key))), 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, _ := localBlocks(t, hex.EncodeToString(FF3Key(mustHex(t, key))), PadCTS)
+	ct, err = FF31Encrypt(enc, 10, make([]byte, 7), pt)
+	if err != nil || ct != "4111111111111111" {
+		t.Errorf("Expected 4111111111111111, got %s, %v", ct, err)
+	}
+}
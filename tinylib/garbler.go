@@ -0,0 +1,99 @@
+package tinylib
+
+import (
+	"context"
+	"sync"
+)
+
+// A ReadyGarbler is a Backend able to tell by itself when its garbler is ready for Bob. For the other backends, such as
+// TinyGarbleBackend, we watch the port until something listens on it.
+type ReadyGarbler interface {
+	// GarbleReady works as Backend.Garble, and calls ready once Bob can connect
+	GarbleReady(ctx context.Context, c Circuit, input string, port int, ready func()) error
+}
+
+// A Garbler is a server started by StartServer or StartRunServer, which is ready for Bob
+type Garbler struct {
+	done chan struct{}
+	err  error
+}
+
+// Wait waits for the server to be done and returns its error
+func (g *Garbler) Wait() error {
+	<-g.done
+	return g.err
+}
+
+// Done is closed once the server is done
+func (g *Garbler) Done() <-chan struct{} {
+	return g.done
+}
+
+// StartServer starts the server (Alice) side for one evaluation of the circuit, as Server does, and returns only once
+// the garbler listens on the port, so Bob can be started right away. Cancelling the context stops the garbler.
+func (s *Session) StartServer(ctx context.Context, data string, port int) (*Garbler, error) {
+	return start(func(ready func()) error {
+		return s.server(ctx, data, port, ready)
+	})
+}
+
+// StartRunServer starts the given number of rounds of servers as RunServer does, and returns once the first garbler
+// listens. The next garblers are only started once the previous one is done, so Bob should rely on the retries of
+// his Client for those, see WithRetry.
+func (s *Session) StartRunServer(ctx context.Context, key string, startingPort int, rounds int) (*Garbler, error) {
+	return start(func(ready func()) error {
+		return s.runServer(ctx, key, startingPort, rounds, ready)
+	})
+}
+
+// Runs the server in a goroutine, and returns once it called ready or is done
+func start(server func(ready func()) error) (*Garbler, error) {
+	g := &Garbler{done: make(chan struct{})}
+	readyC := make(chan struct{})
+	var once sync.Once
+	ready := func() { once.Do(func() { close(readyC) }) }
+
+	go func() {
+		defer close(g.done)
+		g.err = server(ready)
+	}()
+
+	select {
+	case <-readyC:
+		return g, nil
+	case <-g.done:
+		// Bob may have been fast enough for the garbler to be done before we noticed it was listening
+		if g.err != nil {
+			return nil, g.err
+		}
+		return g, nil
+	}
+}
+
+// One evaluation as Alice, calling ready once the garbler listens, if ready isn't nil
+func (s *Session) server(ctx context.Context, data string, port int, ready func()) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if ready == nil {
+		return s.backend.Garble(ctx, s.circuit, data, port)
+	}
+	if rg, ok := s.backend.(ReadyGarbler); ok {
+		return rg.GarbleReady(ctx, s.circuit, data, port, ready)
+	}
+
+	// We watch the port while the backend runs, until it is done
+	done := make(chan struct{})
+	var watcher sync.WaitGroup
+	watcher.Add(1)
+	go func() {
+		defer watcher.Done()
+		if waitListening(ctx, port, done) == nil {
+			ready()
+		}
+	}()
+	err := s.backend.Garble(ctx, s.circuit, data, port)
+	close(done)
+	watcher.Wait()
+	return err
+}
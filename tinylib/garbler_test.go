@@ -0,0 +1,97 @@
+package tinylib
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// A backend which can't tell when it is ready, so that StartServer has to watch the port
+type plainBackend struct {
+	b ReferenceBackend
+}
+
+func (p plainBackend) Garble(ctx context.Context, c Circuit, input string, port int) error {
+	return p.b.Garble(ctx, c, input, port)
+}
+
+func (p plainBackend) Evaluate(ctx context.Context, c Circuit, input string, addr string, port int) (string, error) {
+	return p.b.Evaluate(ctx, c, input, addr, port)
+}
+
+// Once StartServer returns, a client which doesn't retry must succeed right away
+func TestStartServerReady(t *testing.T) {
+	for name, b := range map[string]Backend{"ready": ReferenceBackend{}, "watched": plainBackend{}} {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewSession(WithBackend(b), WithCircuit("hamming_32bit_1cc.scd"), WithRetry(1, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			port := reservePorts(t, 1)
+			g := startGarbling(t, func(ctx context.Context) (*Garbler, error) {
+				return s.StartServer(ctx, "FF55AA77", port)
+			})
+
+			ans, err := s.Client(context.Background(), "12345678", "127.0.0.1", port)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ans != "13\n" {
+				t.Error("Expected 13, got", ans)
+			}
+			if err := g.Wait(); err != nil {
+				t.Error("Server failed:", err)
+			}
+		})
+	}
+}
+
+// StartServer returns the error of a garbler which couldn't start
+func TestStartServerPortUnavailable(t *testing.T) {
+	s := testSession(t, "hamming_32bit_1cc.scd")
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	_, err = s.StartServer(context.Background(), "FF55AA77", l.Addr().(*net.TCPAddr).Port)
+	if !errors.Is(err, ErrPortUnavailable) {
+		t.Error("Expected ErrPortUnavailable, got", err)
+	}
+}
+
+// A client started before its server tries again until the server listens
+func TestClientRetry(t *testing.T) {
+	s, err := NewSession(WithBackend(ReferenceBackend{}), WithCircuit("hamming_32bit_1cc.scd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := reservePorts(t, 1)
+
+	serverDone := startServer(t, func(ctx context.Context) error {
+		time.Sleep(50 * time.Millisecond)
+		return s.Server(ctx, "FF55AA77", port)
+	})
+	ans, err := s.Client(context.Background(), "12345678", "127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ans != "13\n" {
+		t.Error("Expected 13, got", ans)
+	}
+	if err := <-serverDone; err != nil {
+		t.Error("Server failed:", err)
+	}
+
+	// Without retries, the client gives up at once
+	s, err = NewSession(WithBackend(ReferenceBackend{}), WithCircuit("hamming_32bit_1cc.scd"), WithRetry(1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Client(context.Background(), "12345678", "127.0.0.1", reservePorts(t, 1)); !errors.Is(err, ErrConnectFailed) {
+		t.Error("Expected ErrConnectFailed, got", err)
+	}
+}
@@ -0,0 +1,209 @@
+package tinylib
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// GCMNonceSize is the recommended GCM nonce size, for which the initial counter block is simply the nonce followed
+	// by a 32 bits counter set to 1
+	GCMNonceSize = 12
+	// GCMTagSize is the size of the authentication tag appended to the ciphertext
+	GCMTagSize = 16
+	// GCM can't encrypt more than 2^32-2 blocks under one nonce
+	gcmMaxPlaintext uint64 = (1<<32 - 2) * BlockSize
+)
+
+// AESGCMSeal encrypts and authenticates the plaintext, and authenticates the additional data, in GCM mode. Every AES
+// call, including the hash subkey and the tag mask, is an evaluation of the session's AES circuit against Alice's
+// garblers, on the consecutive ports starting at port: she has to run 2 rounds more than the plaintext has blocks.
+// GHASH is computed locally. The output is the ciphertext followed by the 16 bytes tag, as crypto/cipher's GCM gives
+// for the same key, nonce and additional data. Never reuse a nonce with the same key, GCMNonceSize bytes ones are best.
+func (s *Session) AESGCMSeal(ctx context.Context, nonce, plaintext, additionalData []byte, addr string, port int) ([]byte, error) {
+	return GCMSeal(s.Block(ctx, addr, port), nonce, plaintext, additionalData)
+}
+
+// AESGCMOpen checks the tag of a ciphertext of AESGCMSeal and decrypts it, returning ErrAuthFailed if the ciphertext,
+// the nonce or the additional data were tampered with. As for the sealing, the session's circuit is the AES
+// encryption one, and the tag is checked before anything is decrypted.
+func (s *Session) AESGCMOpen(ctx context.Context, nonce, ciphertext, additionalData []byte, addr string, port int) ([]byte, error) {
+	return GCMOpen(s.Block(ctx, addr, port), nonce, ciphertext, additionalData)
+}
+
+// GCMSeal encrypts and authenticates in GCM mode as Session.AESGCMSeal does, every AES call being an evaluation of
+// the garbled block against Alice, e.g. of Conn.Block over a control session.
+func GCMSeal(b *GarbledBlock, nonce, plaintext, additionalData []byte) ([]byte, error) {
+	fmt.Println("\tAES GCM sealing started")
+	if uint64(len(plaintext)) > gcmMaxPlaintext {
+		return nil, fmt.Errorf("tinylib: GCM can't encrypt more than %d bytes", gcmMaxPlaintext)
+	}
+	g, err := newGCM(b, nonce)
+	if err != nil {
+		return nil, err
+	}
+	defer g.clear()
+
+	out := make([]byte, len(plaintext), len(plaintext)+GCMTagSize)
+	if err := g.ctr(out, plaintext); err != nil {
+		return nil, err
+	}
+	return append(out, g.tag(additionalData, out)...), nil
+}
+
+// GCMOpen checks and decrypts a ciphertext of GCMSeal as Session.AESGCMOpen does, returning ErrAuthFailed if it
+// was tampered with.
+func GCMOpen(b *GarbledBlock, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	fmt.Println("\tAES GCM opening started")
+	if len(ciphertext) < GCMTagSize {
+		return nil, fmt.Errorf("%w: a GCM ciphertext has at least its %d bytes tag", ErrDataTooShort, GCMTagSize)
+	}
+	if uint64(len(ciphertext)) > gcmMaxPlaintext+GCMTagSize {
+		return nil, ErrAuthFailed
+	}
+	g, err := newGCM(b, nonce)
+	if err != nil {
+		return nil, err
+	}
+	defer g.clear()
+
+	ciphertext, tag := ciphertext[:len(ciphertext)-GCMTagSize], ciphertext[len(ciphertext)-GCMTagSize:]
+	if subtle.ConstantTimeCompare(g.tag(additionalData, ciphertext), tag) != 1 {
+		return nil, ErrAuthFailed
+	}
+	out := make([]byte, len(ciphertext))
+	if err := g.ctr(out, ciphertext); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// The state of one GCM operation: the hash subkey and the initial counter block, with its encryption masking the tag
+type gcm struct {
+	b         *GarbledBlock
+	h         [BlockSize]byte
+	j0        [BlockSize]byte
+	tagMask   [BlockSize]byte
+	keystream [BlockSize]byte
+}
+
+// Gets the hash subkey H = E(0^128) and the tag mask E(J0) from Alice, in this order
+func newGCM(b *GarbledBlock, nonce []byte) (*gcm, error) {
+	if len(nonce) == 0 {
+		return nil, fmt.Errorf("tinylib: the GCM nonce can't be empty")
+	}
+	g := &gcm{b: b}
+	if err := b.EncryptBlock(g.h[:], g.h[:]); err != nil {
+		return nil, err
+	}
+	if len(nonce) == GCMNonceSize {
+		copy(g.j0[:], nonce)
+		g.j0[BlockSize-1] = 1
+	} else {
+		// Other sizes are hashed, along with their length in bits
+		var lengths [BlockSize]byte
+		binary.BigEndian.PutUint64(lengths[8:], uint64(len(nonce))*8)
+		var y ghash
+		y.init(g.h[:])
+		y.update(nonce)
+		y.update(lengths[:])
+		y.sum(g.j0[:])
+	}
+	if err := b.EncryptBlock(g.tagMask[:], g.j0[:]); err != nil {
+		g.clear()
+		return nil, err
+	}
+	return g, nil
+}
+
+// The CTR mode of GCM, starting at inc32(J0) and only incrementing the last 32 bits, which wrap around
+func (g *gcm) ctr(dst, src []byte) error {
+	var counter [BlockSize]byte
+	copy(counter[:], g.j0[:])
+	for i := 0; i < len(src); i += BlockSize {
+		binary.BigEndian.PutUint32(counter[12:], binary.BigEndian.Uint32(counter[12:])+1)
+		if err := g.b.EncryptBlock(g.keystream[:], counter[:]); err != nil {
+			return err
+		}
+		end := min(i+BlockSize, len(src))
+		subtle.XORBytes(dst[i:end], src[i:end], g.keystream[:end-i])
+	}
+	return nil
+}
+
+// The tag of the ciphertext and additional data, GHASH being computed locally with the hash subkey
+func (g *gcm) tag(additionalData, ciphertext []byte) []byte {
+	var lengths [BlockSize]byte
+	binary.BigEndian.PutUint64(lengths[:8], uint64(len(additionalData))*8)
+	binary.BigEndian.PutUint64(lengths[8:], uint64(len(ciphertext))*8)
+	var y ghash
+	y.init(g.h[:])
+	y.update(additionalData)
+	y.update(ciphertext)
+	y.update(lengths[:])
+	tag := make([]byte, GCMTagSize)
+	y.sum(tag)
+	subtle.XORBytes(tag, tag, g.tagMask[:])
+	return tag
+}
+
+// Zeroes the key dependent values
+func (g *gcm) clear() {
+	clear(g.h[:])
+	clear(g.tagMask[:])
+	clear(g.keystream[:])
+}
+
+// GHASH, with the blocks as two big endian uint64, the first bit of the block being the highest of hi
+type ghash struct {
+	hHi, hLo uint64
+	yHi, yLo uint64
+}
+
+func (y *ghash) init(h []byte) {
+	y.hHi, y.hLo = binary.BigEndian.Uint64(h), binary.BigEndian.Uint64(h[8:])
+	y.yHi, y.yLo = 0, 0
+}
+
+// Hashes the data, its last block being padded with 0's
+func (y *ghash) update(data []byte) {
+	var block [BlockSize]byte
+	for len(data) > 0 {
+		n := copy(block[:], data)
+		clear(block[n:])
+		data = data[n:]
+		y.yHi ^= binary.BigEndian.Uint64(block[:])
+		y.yLo ^= binary.BigEndian.Uint64(block[8:])
+		y.mul()
+	}
+}
+
+// Multiplies Y by H in GF(2^128), bit by bit and without branching on secret data, as in SP 800-38D algorithm 1
+func (y *ghash) mul() {
+	var zHi, zLo uint64
+	vHi, vLo := y.hHi, y.hLo
+	for i := 0; i < 128; i++ {
+		var bit uint64
+		if i < 64 {
+			bit = y.yHi >> (63 - i) & 1
+		} else {
+			bit = y.yLo >> (127 - i) & 1
+		}
+		mask := -bit
+		zHi ^= vHi & mask
+		zLo ^= vLo & mask
+		// V times x, reducing by x^128 + x^7 + x^2 + x + 1 when the last bit falls off
+		reduce := -(vLo & 1)
+		vLo = vLo>>1 | vHi<<63
+		vHi = vHi>>1 ^ 0xe1<<56&reduce
+	}
+	y.yHi, y.yLo = zHi, zLo
+}
+
+func (y *ghash) sum(dst []byte) {
+	binary.BigEndian.PutUint64(dst, y.yHi)
+	binary.BigEndian.PutUint64(dst[8:], y.yLo)
+	y.hHi, y.hLo, y.yHi, y.yLo = 0, 0, 0, 0
+}
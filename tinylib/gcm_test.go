@@ -0,0 +1,106 @@
+package tinylib
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"testing"
+)
+
+// The same output as the standard library, for every nonce size and length, and back
+func TestGCMStandard(t *testing.T) {
+	key := "feffe9928665731c6d6a8f9467308308"
+	enc, _ := localBlocks(t, key, PadCTS)
+	aesBlock, err := aes.NewCipher(mustHex(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := bytes.Repeat([]byte("GCM through garbled circuits. "), 4)
+	aad := []byte("feedfacedeadbeef")
+	for _, nonceSize := range []int{12, 8, 16, 60} {
+		nonce := bytes.Repeat([]byte{0xca, 0xfe, 0xba, 0xbe}, 15)[:nonceSize]
+		gcm, err := cipher.NewGCMWithNonceSize(aesBlock, nonceSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range []int{0, 1, 16, 31, 60, len(plaintext)} {
+			for _, ad := range [][]byte{nil, aad} {
+				expected := gcm.Seal(nil, nonce, plaintext[:n], ad)
+				sealed, err := GCMSeal(enc, nonce, plaintext[:n], ad)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(sealed, expected) {
+					t.Errorf("Nonce of %d bytes, %d bytes: expected %x, got %x", nonceSize, n, expected, sealed)
+				}
+				opened, err := GCMOpen(enc, nonce, sealed, ad)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(opened, plaintext[:n]) {
+					t.Errorf("Nonce of %d bytes, %d bytes: expected %q, got %q", nonceSize, n, plaintext[:n], opened)
+				}
+			}
+		}
+	}
+}
+
+// Any change to the ciphertext, the tag, the nonce or the additional data is detected
+func TestGCMTampering(t *testing.T) {
+	enc, _ := localBlocks(t, "feffe9928665731c6d6a8f9467308308", PadCTS)
+	nonce := mustHex(t, "cafebabefacedbaddecaf888")
+	aad := []byte("header")
+	sealed, err := GCMSeal(enc, nonce, []byte("attack at dawn"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{0, 13, len(sealed) - 1} {
+		tampered := bytes.Clone(sealed)
+		tampered[i] ^= 1
+		if _, err := GCMOpen(enc, nonce, tampered, aad); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("Byte %d flipped: expected ErrAuthFailed, got %v", i, err)
+		}
+	}
+	if _, err := GCMOpen(enc, mustHex(t, "cafebabefacedbaddecaf889"), sealed, aad); !errors.Is(err, ErrAuthFailed) {
+		t.Error("Expected ErrAuthFailed for another nonce, got", err)
+	}
+	if _, err := GCMOpen(enc, nonce, sealed, []byte("Header")); !errors.Is(err, ErrAuthFailed) {
+		t.Error("Expected ErrAuthFailed for other additional data, got", err)
+	}
+	if _, err := GCMOpen(enc, nonce, sealed[:GCMTagSize-1], aad); !errors.Is(err, ErrDataTooShort) {
+		t.Error("Expected ErrDataTooShort without a full tag, got", err)
+	}
+	if _, err := GCMSeal(enc, nil, []byte("data"), nil); err == nil {
+		t.Error("Expected an error for an empty nonce")
+	}
+}
+
+// Test case 4 of the GCM specification through the garblers: one for the hash subkey, one for the tag mask and one
+// per block of data
+func TestAESGCM(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	key := "feffe9928665731c6d6a8f9467308308"
+	nonce := mustHex(t, "cafebabefacedbaddecaf888")
+	pt := mustHex(t, "d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a721c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b39")
+	aad := mustHex(t, "feedfacedeadbeeffeedfacedeadbeefabaddad2")
+	expected := mustHex(t, "42831ec2217774244b7221b784d0d49ce3aa212f2c02a4e035c17e2329aca12e21d514b25466931c7d8f6a5aac84aa051ba30b396a0aac973d58e091"+
+		"5bc94fbc3221a5db94fae95ae7121a47")
+	ctx := context.Background()
+
+	sealed, err := s.AESGCMSeal(ctx, nonce, pt, aad, "127.0.0.1", startAES(t, s, key, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sealed, expected) {
+		t.Errorf("Expected %x, got %x", expected, sealed)
+	}
+	opened, err := s.AESGCMOpen(ctx, nonce, sealed, aad, "127.0.0.1", startAES(t, s, key, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, pt) {
+		t.Errorf("Expected %x, got %x", pt, opened)
+	}
+}
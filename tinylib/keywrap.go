@@ -0,0 +1,188 @@
+package tinylib
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+)
+
+// The default initial value of RFC 3394, and the first half of the alternative one of RFC 5649
+var (
+	keyWrapIV  = [8]byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+	keyWrapAIV = [4]byte{0xa6, 0x59, 0x59, 0xa6}
+)
+
+// AESKeyWrap wraps the key, e.g. a data encryption key, under Alice's key with the AES key wrap of RFC 3394, so that
+// only Alice's garblers can unwrap it, much like a KMS would. The key has to be a multiple of 8 bytes long, and at least
+// 16 bytes, see AESKeyWrapPad otherwise. The session's circuit is the AES encryption one, garbled on the consecutive
+// ports starting at port: wrapping a key of n 8 bytes blocks uses 6n of them.
+func (s *Session) AESKeyWrap(ctx context.Context, key []byte, addr string, port int) ([]byte, error) {
+	return KeyWrap(s.Block(ctx, addr, port), key)
+}
+
+// AESKeyUnwrap unwraps a key wrapped by AESKeyWrap, returning ErrAuthFailed if its integrity check fails. The session's
+// circuit has to be the AES decryption (inverse cipher) one, garbled by Alice with the same key, see DecryptCBC.
+func (s *Session) AESKeyUnwrap(ctx context.Context, wrapped []byte, addr string, port int) ([]byte, error) {
+	return KeyUnwrap(s.Block(ctx, addr, port), wrapped)
+}
+
+// AESKeyWrapPad wraps a key of any length with the padded key wrap of RFC 5649, using the AES encryption circuit as
+// AESKeyWrap does. A key of at most 8 bytes only uses one port, one of n 8 bytes blocks once padded uses 6n of them.
+func (s *Session) AESKeyWrapPad(ctx context.Context, key []byte, addr string, port int) ([]byte, error) {
+	return KeyWrapPad(s.Block(ctx, addr, port), key)
+}
+
+// AESKeyUnwrapPad unwraps a key wrapped by AESKeyWrapPad, using the AES decryption circuit as AESKeyUnwrap does.
+func (s *Session) AESKeyUnwrapPad(ctx context.Context, wrapped []byte, addr string, port int) ([]byte, error) {
+	return KeyUnwrapPad(s.Block(ctx, addr, port), wrapped)
+}
+
+// KeyWrap wraps the key with the AES key wrap of RFC 3394, each AES call being an evaluation of the garbled block
+// against Alice, e.g. of Conn.Block over a control session. See Session.AESKeyWrap.
+func KeyWrap(b *GarbledBlock, key []byte) ([]byte, error) {
+	fmt.Println("\tAES key wrap started")
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, fmt.Errorf("%w: the wrapped key is a multiple of 64 bits, of at least 128 bits", ErrDataTooShort)
+	}
+	return wrapW(b, keyWrapIV, key)
+}
+
+// KeyUnwrap unwraps a key wrapped by KeyWrap, the garbled block being the AES decryption circuit. See
+// Session.AESKeyUnwrap.
+func KeyUnwrap(b *GarbledBlock, wrapped []byte) ([]byte, error) {
+	fmt.Println("\tAES key unwrap started")
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, fmt.Errorf("%w: a wrapped key is a multiple of 64 bits, of at least 192 bits", ErrDataTooShort)
+	}
+	a, key, err := unwrapW(b, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(a[:], keyWrapIV[:]) != 1 {
+		clear(key)
+		return nil, ErrAuthFailed
+	}
+	return key, nil
+}
+
+// KeyWrapPad wraps a key of any length with the padded key wrap of RFC 5649, whose initial value holds the length of
+// the key, padded with 0's. See Session.AESKeyWrapPad.
+func KeyWrapPad(b *GarbledBlock, key []byte) ([]byte, error) {
+	fmt.Println("\tAES padded key wrap started")
+	if len(key) == 0 || uint64(len(key)) > 1<<32-1 {
+		return nil, fmt.Errorf("tinylib: can't wrap a key of %d bytes", len(key))
+	}
+	var aiv [8]byte
+	copy(aiv[:], keyWrapAIV[:])
+	binary.BigEndian.PutUint32(aiv[4:], uint32(len(key)))
+	padded := make([]byte, (len(key)+7)/8*8)
+	defer clear(padded)
+	copy(padded, key)
+
+	// A single block is simply encrypted
+	if len(padded) == 8 {
+		out := make([]byte, BlockSize)
+		copy(out, aiv[:])
+		copy(out[8:], padded)
+		if err := b.EncryptBlock(out, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	return wrapW(b, aiv, padded)
+}
+
+// KeyUnwrapPad unwraps a key wrapped by KeyWrapPad, the garbled block being the AES decryption circuit. See
+// Session.AESKeyUnwrapPad.
+func KeyUnwrapPad(b *GarbledBlock, wrapped []byte) ([]byte, error) {
+	fmt.Println("\tAES padded key unwrap started")
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, fmt.Errorf("%w: a wrapped key is a multiple of 64 bits, of at least 128 bits", ErrDataTooShort)
+	}
+	var a [8]byte
+	var padded []byte
+	if len(wrapped) == BlockSize {
+		block := make([]byte, BlockSize)
+		if err := b.EncryptBlock(block, wrapped); err != nil {
+			return nil, err
+		}
+		copy(a[:], block)
+		padded = block[8:]
+		clear(block[:8])
+	} else {
+		var err error
+		if a, padded, err = unwrapW(b, wrapped); err != nil {
+			return nil, err
+		}
+	}
+
+	// The initial value, the length and the padding are all checked, without telling which one is wrong
+	n := len(padded)
+	mli := uint64(binary.BigEndian.Uint32(a[4:]))
+	good := subtle.ConstantTimeCompare(a[:4], keyWrapAIV[:])
+	// n-7 <= mli <= n, i.e. n-mli is between 0 and 7, which wraps around to a huge number if mli > n
+	inRange := int(((uint64(n)-mli)>>3 - 1) >> 63)
+	good &= inRange
+	length := subtle.ConstantTimeSelect(inRange, int(mli), n)
+	for i := n - 7; i < n; i++ {
+		inPadding := subtle.ConstantTimeLessOrEq(length, i)
+		good &= (1 ^ inPadding) | subtle.ConstantTimeByteEq(padded[i], 0)
+	}
+	if good != 1 {
+		clear(padded)
+		return nil, ErrAuthFailed
+	}
+	return padded[:length], nil
+}
+
+// The wrapping process W of RFC 3394 section 2.2.1, in its indexed form, with the given initial value
+func wrapW(b *GarbledBlock, iv [8]byte, key []byte) ([]byte, error) {
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out[8:], key)
+	block := make([]byte, BlockSize)
+	defer clear(block)
+	copy(block, iv[:])
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			r := out[8*i : 8*i+8]
+			copy(block[8:], r)
+			if err := b.EncryptBlock(block, block); err != nil {
+				return nil, err
+			}
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(block, binary.BigEndian.Uint64(block)^t)
+			copy(r, block[8:])
+		}
+	}
+	copy(out, block[:8])
+	return out, nil
+}
+
+// The unwrapping process W^-1 of RFC 3394 section 2.2.2, b being the AES decryption one. It returns the initial value
+// found, which the caller checks, and the key.
+func unwrapW(b *GarbledBlock, wrapped []byte) ([8]byte, []byte, error) {
+	var a [8]byte
+	n := len(wrapped)/8 - 1
+	key := make([]byte, 8*n)
+	copy(key, wrapped[8:])
+	block := make([]byte, BlockSize)
+	defer clear(block)
+	copy(block, wrapped[:8])
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			r := key[8*(i-1) : 8*i]
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(block, binary.BigEndian.Uint64(block)^t)
+			copy(block[8:], r)
+			if err := b.EncryptBlock(block, block); err != nil {
+				clear(key)
+				return a, nil, err
+			}
+			copy(r, block[8:])
+		}
+	}
+	copy(a[:], block[:8])
+	return a, key, nil
+}
@@ -0,0 +1,163 @@
+package tinylib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+// Some of the examples of RFC 3394 section 4, and those of RFC 5649 section 6, with 192 bits keys
+var keyWrapVectors = []struct {
+	kek, key, wrapped string
+	pad               bool
+}{
+	{"000102030405060708090a0b0c0d0e0f", "00112233445566778899aabbccddeeff", "1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5", false},
+	{"000102030405060708090a0b0c0d0e0f1011121314151617", "00112233445566778899aabbccddeeff0001020304050607", "031d33264e15d33268f24ec260743edce1c6c7ddee725a936ba814915c6762d2", false},
+	{"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", "00112233445566778899aabbccddeeff000102030405060708090a0b0c0d0e0f", "28c9f404c4b810f4cbccb35cfb87f8263f5786e2d80ed326cbc7f0e71a99f43bfb988b9b7a02dd21", false},
+	{"5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8", "c37b7e6492584340bed12207808941155068f738", "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a", true},
+	{"5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8", "466f7250617369", "afbeb0f07dfbf5419200f2ccb50bb24f", true},
+}
+
+func TestKeyWrap(t *testing.T) {
+	for _, v := range keyWrapVectors {
+		enc, dec := localBlocks(t, v.kek, PadCTS)
+		wrap, unwrap := KeyWrap, KeyUnwrap
+		if v.pad {
+			wrap, unwrap = KeyWrapPad, KeyUnwrapPad
+		}
+		key, expected := mustHex(t, v.key), mustHex(t, v.wrapped)
+		wrapped, err := wrap(enc, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wrapped, expected) {
+			t.Errorf("Expected %x, got %x", expected, wrapped)
+		}
+		unwrapped, err := unwrap(dec, wrapped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(unwrapped, key) {
+			t.Errorf("Expected %x, got %x", key, unwrapped)
+		}
+
+		// Any change fails the integrity check
+		for _, i := range []int{0, 8, len(wrapped) - 1} {
+			tampered := bytes.Clone(wrapped)
+			tampered[i] ^= 1
+			if _, err := unwrap(dec, tampered); !errors.Is(err, ErrAuthFailed) {
+				t.Errorf("%x, byte %d flipped: expected ErrAuthFailed, got %v", wrapped, i, err)
+			}
+		}
+	}
+}
+
+// The padded key wrap checks the length and the padding too, whatever the number of blocks
+func TestKeyWrapPadding(t *testing.T) {
+	enc, dec := localBlocks(t, "5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8", PadCTS)
+	for n := 1; n <= 25; n++ {
+		key := bytes.Repeat([]byte{0x5a}, n)
+		wrapped, err := KeyWrapPad(enc, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(wrapped) != 8+(n+7)/8*8 || n <= 8 && len(wrapped) != BlockSize {
+			t.Errorf("%d bytes: unexpected length %d", n, len(wrapped))
+		}
+		unwrapped, err := KeyUnwrapPad(dec, wrapped)
+		if err != nil || !bytes.Equal(unwrapped, key) {
+			t.Errorf("%d bytes: expected %x, got %x, %v", n, key, unwrapped, err)
+		}
+		// RFC 3394 doesn't unwrap them
+		if _, err := KeyUnwrap(dec, wrapped); err == nil {
+			t.Errorf("%d bytes: expected an error unwrapping without padding", n)
+		}
+	}
+
+	// Wrapping with the 3394 initial value a key which looks padded isn't accepted by the 5649 unwrap
+	wrapped, err := wrapW(enc, keyWrapIV, make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := KeyUnwrapPad(dec, wrapped); !errors.Is(err, ErrAuthFailed) {
+		t.Error("Expected ErrAuthFailed, got", err)
+	}
+	// Nor a length which doesn't match the padded key, nor a non zero padding
+	for _, iv := range []string{"a65959a600000008", "a65959a600000011", "a65959a6ffffffff", "a65959a60000000f"} {
+		padded := append(bytes.Repeat([]byte{1}, 15), 0)
+		if iv == "a65959a60000000f" {
+			padded[15] = 1
+		}
+		wrapped, err := wrapW(enc, [8]byte(mustHex(t, iv)), padded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := KeyUnwrapPad(dec, wrapped); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("%s: expected ErrAuthFailed, got %v", iv, err)
+		}
+	}
+
+	if _, err := KeyWrap(enc, make([]byte, 12)); !errors.Is(err, ErrDataTooShort) {
+		t.Error("Expected ErrDataTooShort, got", err)
+	}
+	if _, err := KeyUnwrapPad(dec, make([]byte, 12)); !errors.Is(err, ErrDataTooShort) {
+		t.Error("Expected ErrDataTooShort, got", err)
+	}
+	if _, err := KeyWrapPad(enc, nil); err == nil {
+		t.Error("Expected an error for an empty key")
+	}
+}
+
+// A data encryption key wrapped and unwrapped through the garblers
+func TestAESKeyWrap(t *testing.T) {
+	enc := testSession(t, "aes_1cc.scd")
+	dec := testSession(t, "aes_dec_1cc.scd")
+	v := keyWrapVectors[0]
+	ctx := context.Background()
+	wrapped, err := enc.AESKeyWrap(ctx, mustHex(t, v.key), "127.0.0.1", startAES(t, enc, v.kek, 12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wrapped, mustHex(t, v.wrapped)) {
+		t.Errorf("Expected %s, got %x", v.wrapped, wrapped)
+	}
+	key, err := dec.AESKeyUnwrap(ctx, wrapped, "127.0.0.1", startAES(t, dec, v.kek, 12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, mustHex(t, v.key)) {
+		t.Errorf("Expected %s, got %x", v.key, key)
+	}
+
+	wrapped, err = enc.AESKeyWrapPad(ctx, []byte("short"), "127.0.0.1", startAES(t, enc, v.kek, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = dec.AESKeyUnwrapPad(ctx, wrapped, "127.0.0.1", startAES(t, dec, v.kek, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key) != "short" {
+		t.Errorf("Expected short, got %q", key)
+	}
+}
+
+// Over a control session, the free functions take the Conn's block
+func TestControlKeyWrap(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	v := keyWrapVectors[0]
+	ctx := context.Background()
+	c, err := s.Dial(ctx, startControlServer(t, s, v.kek), "aes_1cc.scd", 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	wrapped, err := KeyWrap(c.Block(ctx), mustHex(t, v.key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wrapped, mustHex(t, v.wrapped)) {
+		t.Errorf("Expected %s, got %x", v.wrapped, wrapped)
+	}
+}
@@ -0,0 +1,258 @@
+package tinylib
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// BlockSize is the AES block size in bytes
+const BlockSize = 16
+
+// NewIV returns a random IV, from the secure PRNG of "crypto/rand". Never reuse an IV with the same key.
+func NewIV() ([BlockSize]byte, error) {
+	var iv [BlockSize]byte
+	if _, err := rand.Read(iv[:]); err != nil {
+		return iv, fmt.Errorf("tinylib: iv generation failed: %w", err)
+	}
+	return iv, nil
+}
+
+// EncryptCBC encrypts the plaintext in CBC mode with ciphertext stealing, so that the ciphertext is as long as the
+// plaintext, using the session's AES circuit on the consecutive ports starting at port. The plaintext has to be at
+// least one block long, otherwise ErrDataTooShort is returned. With a padding set by WithPadding, the plaintext can have
+// any length, and the ciphertext has one block more than its full blocks.
+func (s *Session) EncryptCBC(ctx context.Context, plaintext []byte, iv [BlockSize]byte, addr string, port int) ([]byte, error) {
+	return CBCEncrypt(s.Block(ctx, addr, port), plaintext, iv)
+}
+
+// EncryptCTR encrypts the plaintext, of any length, in CTR mode using the session's AES circuit on the consecutive
+// ports starting at port. The IV is the initial counter block, incremented for each block as the session's
+// CounterLayout says, ErrCounterExhausted being returned if the counter would wrap around.
+func (s *Session) EncryptCTR(ctx context.Context, plaintext []byte, iv [BlockSize]byte, addr string, port int) ([]byte, error) {
+	return CTREncrypt(s.Block(ctx, addr, port), plaintext, iv)
+}
+
+// CBCEncrypt encrypts the plaintext in CBC mode, as Session.EncryptCBC does, each block being one evaluation of the
+// garbled block against Alice, e.g. of Conn.Block over a control session. See also CBCWriter.
+func CBCEncrypt(b *GarbledBlock, plaintext []byte, iv [BlockSize]byte) ([]byte, error) {
+	fmt.Println("\tAES CBC started")
+	ciphertext := bytes.NewBuffer(make([]byte, 0, len(plaintext)+BlockSize))
+	w := NewCBCWriter(ciphertext, b, iv)
+	if _, err := w.Write(plaintext); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return ciphertext.Bytes(), nil
+}
+
+// CTREncrypt encrypts the plaintext in CTR mode, as Session.EncryptCTR does, each counter block being one evaluation of
+// the garbled block.
+func CTREncrypt(b *GarbledBlock, plaintext []byte, iv [BlockSize]byte) ([]byte, error) {
+	fmt.Println("\tAES CTR started")
+	s := newCTRStream(b, iv)
+	defer s.clear()
+	ciphertext := make([]byte, len(plaintext))
+	if _, err := s.xorKeyStream(ciphertext, plaintext); err != nil {
+		return nil, err
+	}
+	return ciphertext, nil
+}
+
+// CBCDecrypt decrypts a ciphertext of CBCEncrypt, as Session.DecryptCBC does, each block being decrypted by the garbled
+// block, whose circuit has to be the AES decryption one. It undoes the ciphertext stealing, or removes the padding.
+func CBCDecrypt(b *GarbledBlock, ciphertext []byte, iv [BlockSize]byte) ([]byte, error) {
+	fmt.Println("\tAES CBC decryption started")
+	if len(ciphertext) < BlockSize {
+		return nil, fmt.Errorf("%w: a CBC ciphertext is at least 128 bits long", ErrDataTooShort)
+	}
+	if b.padding != PadCTS && len(ciphertext)%BlockSize != 0 {
+		return nil, fmt.Errorf("%w: with %v, a CBC ciphertext is a whole number of blocks", ErrInvalidPadding, b.padding)
+	}
+	plaintext := make([]byte, len(ciphertext))
+	// The blocks before the two swapped by the ciphertext stealing, if the last one is partial, are simply chained
+	d := len(ciphertext) % BlockSize
+	chained := len(ciphertext)
+	if d != 0 {
+		chained -= BlockSize + d
+	}
+	prev := iv[:]
+	for i := 0; i < chained; i += BlockSize {
+		block := plaintext[i : i+BlockSize]
+		if err := b.EncryptBlock(block, ciphertext[i:i+BlockSize]); err != nil {
+			return nil, err
+		}
+		subtle.XORBytes(block, block, prev)
+		prev = ciphertext[i : i+BlockSize]
+	}
+	if d == 0 {
+		return unpad(plaintext, b.padding)
+	}
+
+	// ciphertext stealing undone: the full block encrypts the last plaintext block padded with 0's and xored with the
+	// previous ciphertext block, of which only the first d bytes follow. Its end is thus given by the decryption.
+	last, stolen := ciphertext[chained:chained+BlockSize], ciphertext[chained+BlockSize:]
+	x := make([]byte, BlockSize)
+	defer clear(x)
+	if err := b.EncryptBlock(x, last); err != nil {
+		return nil, err
+	}
+	subtle.XORBytes(plaintext[chained+BlockSize:], x[:d], stolen)
+	previous := make([]byte, BlockSize)
+	copy(previous, stolen)
+	copy(previous[d:], x[d:])
+	block := plaintext[chained : chained+BlockSize]
+	if err := b.EncryptBlock(block, previous); err != nil {
+		return nil, err
+	}
+	subtle.XORBytes(block, block, prev)
+	return plaintext, nil
+}
+
+// DecryptCBC decrypts a ciphertext of EncryptCBC, or AESCBC, undoing its ciphertext stealing. The session's circuit
+// has to be the AES decryption (inverse cipher) one, garbled by Alice with the same key, on the consecutive ports
+// starting at port. The ciphertext has to be at least one block long, otherwise ErrDataTooShort is returned.
+// With a padding set by WithPadding, the padding is checked and removed, ErrInvalidPadding being returned if it's wrong.
+func (s *Session) DecryptCBC(ctx context.Context, ciphertext []byte, iv [BlockSize]byte, addr string, port int) ([]byte, error) {
+	return CBCDecrypt(s.Block(ctx, addr, port), ciphertext, iv)
+}
+
+// DecryptCTR decrypts a ciphertext of EncryptCTR, which is the same as encrypting it again: the session's circuit is
+// the AES encryption one.
+func (s *Session) DecryptCTR(ctx context.Context, ciphertext []byte, iv [BlockSize]byte, addr string, port int) ([]byte, error) {
+	return CTRDecrypt(s.Block(ctx, addr, port), ciphertext, iv)
+}
+
+// DecryptCTRAt decrypts the part of a ciphertext of EncryptCTR starting at the given byte offset, iv being its initial
+// counter block. Only the counter blocks of this part are encrypted, using one port each starting at port.
+func (s *Session) DecryptCTRAt(ctx context.Context, ciphertext []byte, offset int64, iv [BlockSize]byte, addr string, port int) ([]byte, error) {
+	return CTRDecryptAt(s.Block(ctx, addr, port), ciphertext, offset, iv)
+}
+
+// CTRDecrypt decrypts a ciphertext of CTREncrypt, which is the same as encrypting it again with the AES encryption
+// circuit.
+func CTRDecrypt(b *GarbledBlock, ciphertext []byte, iv [BlockSize]byte) ([]byte, error) {
+	return CTREncrypt(b, ciphertext, iv)
+}
+
+// CTRDecryptAt decrypts the part of a ciphertext of CTREncrypt starting at the given byte offset, as
+// Session.DecryptCTRAt does, only evaluating the garbled block for the counter blocks of this part.
+func CTRDecryptAt(b *GarbledBlock, data []byte, offset int64, iv [BlockSize]byte) ([]byte, error) {
+	fmt.Println("\tAES CTR started at offset", offset)
+	s := newCTRStream(b, iv)
+	defer s.clear()
+	if err := s.seek(offset); err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	if _, err := s.xorKeyStream(out, data); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Runs one block through the garbled AES circuit of the peer, which uses little endian
+func evaluateBlock(ctx context.Context, p peer, dst, src []byte) error {
+	le := make([]byte, BlockSize)
+	defer clear(le)
+	reverseBytes(le, src)
+	out, err := p.evaluate(ctx, hex.EncodeToString(le))
+	if err != nil {
+		return err
+	}
+	if err := decodeBlock(le, out); err != nil {
+		return err
+	}
+	reverseBytes(dst, le)
+	return nil
+}
+
+// Decodes the hexadecimal output of the circuit, which has to be one block
+func decodeBlock(dst []byte, out string) error {
+	out = strings.TrimSpace(out)
+	if hex.DecodedLen(len(out)) != len(dst) {
+		return fmt.Errorf("tinylib: the circuit output %d characters instead of %d", len(out), hex.EncodedLen(len(dst)))
+	}
+	if _, err := hex.Decode(dst, []byte(out)); err != nil {
+		return fmt.Errorf("%w: circuit output: %v", ErrInvalidHex, err)
+	}
+	return nil
+}
+
+// Writes the bytes of src in reverse order into dst, which may be src itself
+func reverseBytes(dst, src []byte) {
+	n := len(src)
+	for i := 0; i < (n+1)/2; i++ {
+		dst[i], dst[n-1-i] = src[n-1-i], src[i]
+	}
+}
+
+// The AES key as Alice's input to the circuit: in hexadecimal and little endian
+func aesKeyInput(key []byte) (string, error) {
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return "", fmt.Errorf("tinylib: invalid AES key size %d", len(key))
+	}
+	le := make([]byte, len(key))
+	defer clear(le)
+	reverseBytes(le, key)
+	return hex.EncodeToString(le), nil
+}
+
+// KeySize returns the key size in bytes of the session's AES circuit, as set by WithKeySize. Without it, the size is
+// picked up from the name of the circuit file, which has to be one of TinyGarble's: 16 for aes_1cc.scd or
+// aes_dec_1cc.scd, 24 for AES-192 with "_192" after "aes" or "aes_dec", such as aes_192_1cc.scd, and 32 for AES-256
+// with "_256". Any other name is an error. Whatever the key size, the blocks and the IVs are 128 bits long.
+func (s *Session) KeySize() (int, error) {
+	if s.keySize != 0 {
+		return s.keySize, nil
+	}
+	return aesKeySize(s.circuit.Path)
+}
+
+// The names of the AES circuit files whose key size is known, the size in bits being the second group
+var aesCircuitName = regexp.MustCompile(`^aes(_dec)?(?:_(128|192|256))?_[0-9]+cc\.scd$`)
+
+// The key size in bytes of the AES circuit, from the name of its file, see KeySize
+func aesKeySize(path string) (int, error) {
+	m := aesCircuitName.FindStringSubmatch(filepath.Base(path))
+	switch {
+	case m == nil:
+		return 0, fmt.Errorf("tinylib: can't tell the key size of the circuit %s from its name, use WithKeySize", filepath.Base(path))
+	case m[2] == "192":
+		return 24, nil
+	case m[2] == "256":
+		return 32, nil
+	}
+	return 16, nil
+}
+
+// Alice's input for the session's AES circuit, checking that the key has the size the circuit expects
+func (s *Session) aesKey(key []byte) (string, error) {
+	size, err := s.KeySize()
+	if err != nil {
+		return "", err
+	}
+	if len(key) != size {
+		return "", fmt.Errorf("tinylib: the circuit %s takes %d bytes keys, got %d bytes", filepath.Base(s.circuit.Path), size, len(key))
+	}
+	return aesKeyInput(key)
+}
+
+// RunServerKey works as RunServer for the AES circuit, taking the key as bytes in the usual big endian order. Its size
+// has to be the one of the circuit, see KeySize.
+func (s *Session) RunServerKey(ctx context.Context, key []byte, startingPort int, rounds int) error {
+	input, err := s.aesKey(key)
+	if err != nil {
+		return err
+	}
+	return s.RunServer(ctx, input, startingPort, rounds)
+}
@@ -0,0 +1,337 @@
+package tinylib
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Starts Alice with the AES key for the given number of blocks, and returns the first port Bob has to use
+func startAES(t *testing.T, s *Session, key string, blocks int) int {
+	k, err := hex.DecodeString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, err := aesKeyInput(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := reservePorts(t, blocks)
+	startGarbling(t, func(ctx context.Context) (*Garbler, error) {
+		return s.StartRunServer(ctx, input, port, blocks)
+	})
+	return port
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// The CBC vectors with ciphertext stealing, which only swaps the last two blocks when the last one is partial, as in
+// CBC-CS2 of the SP 800-38A addendum. Those with a partial block are the RFC 3962 ones, since CS2 and CS3 agree on them,
+// the others are the RFC 3962 ones without the swap of CS3, and the SP 800-38A F.2.1 ones.
+var cbcVectors = []struct{ key, iv, plain, cipher string }{
+	{rfc3962Key, zeroIV, rfc3962Plain[:34], "c6353568f2bf8cb4d8a580362da7ff7f97"},
+	{rfc3962Key, zeroIV, rfc3962Plain[:62], "fc00783e0efdb2c1d445d4c8eff7ed2297687268d6ecccc0c07b25e25ecfe5"},
+	{rfc3962Key, zeroIV, rfc3962Plain[:64], "97687268d6ecccc0c07b25e25ecfe58439312523a78662d5be7fcbcc98ebf5a8"},
+	{rfc3962Key, zeroIV, rfc3962Plain[:94], "97687268d6ecccc0c07b25e25ecfe584b3fffd940c16a18c1b5549d2f838029e39312523a78662d5be7fcbcc98ebf5"},
+	{rfc3962Key, zeroIV, rfc3962Plain[:96], "97687268d6ecccc0c07b25e25ecfe58439312523a78662d5be7fcbcc98ebf5a89dad8bbb96c4cdc03bc103e1a194bbd8"},
+	{rfc3962Key, zeroIV, rfc3962Plain, "97687268d6ecccc0c07b25e25ecfe58439312523a78662d5be7fcbcc98ebf5a89dad8bbb96c4cdc03bc103e1a194bbd84807efe836ee89a526730dbc2f7bc840"},
+	{"2b7e151628aed2a6abf7158809cf4f3c", "000102030405060708090a0b0c0d0e0f",
+		"6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710",
+		"7649abac8119b246cee98e9b12e9197d5086cb9b507219ee95db113a917678b273bed6b8e3c1743b7116e69e222295163ff1caa1681fac09120eca307586e1a7"},
+}
+
+const (
+	rfc3962Key   = "636869636b656e207465726979616b69"
+	rfc3962Plain = "4920776f756c64206c696b65207468652047656e6572616c20476175277320436869636b656e2c20706c656173652c20616e6420776f6e746f6e20736f75702e"
+	zeroIV       = "00000000000000000000000000000000"
+)
+
+func TestEncryptCBC(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	for _, v := range cbcVectors {
+		pt := mustHex(t, v.plain)
+		blocks := (len(pt) + BlockSize - 1) / BlockSize
+		port := startAES(t, s, v.key, blocks)
+		ct, err := s.EncryptCBC(context.Background(), pt, [BlockSize]byte(mustHex(t, v.iv)), "127.0.0.1", port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ct, mustHex(t, v.cipher)) {
+			t.Errorf("%d bytes: expected %s, got %x", len(pt), v.cipher, ct)
+		}
+	}
+
+	if _, err := s.EncryptCBC(context.Background(), make([]byte, 15), [BlockSize]byte{}, "127.0.0.1", 1); !errors.Is(err, ErrDataTooShort) {
+		t.Error("Expected ErrDataTooShort, got", err)
+	}
+}
+
+// The same vectors decrypted with the AES decryption circuit
+func TestDecryptCBC(t *testing.T) {
+	s := testSession(t, "aes_dec_1cc.scd")
+	for _, v := range cbcVectors {
+		ct := mustHex(t, v.cipher)
+		blocks := (len(ct) + BlockSize - 1) / BlockSize
+		port := startAES(t, s, v.key, blocks)
+		pt, err := s.DecryptCBC(context.Background(), ct, [BlockSize]byte(mustHex(t, v.iv)), "127.0.0.1", port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(pt, mustHex(t, v.plain)) {
+			t.Errorf("%d bytes: expected %s, got %x", len(ct), v.plain, pt)
+		}
+	}
+
+	if _, err := s.DecryptCBC(context.Background(), make([]byte, 15), [BlockSize]byte{}, "127.0.0.1", 1); !errors.Is(err, ErrDataTooShort) {
+		t.Error("Expected ErrDataTooShort, got", err)
+	}
+	if _, err := s.AESCBCDecrypt(context.Background(), "00112233445566778899aabbccddeeff", "127.0.0.1", 1, "0011"); !errors.Is(err, ErrInvalidHex) {
+		t.Error("Expected ErrInvalidHex for a short iv, got", err)
+	}
+}
+
+// The SP 800-38A F.5.1 vectors, cut in the middle of the last block
+func TestEncryptCTR(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	port := startAES(t, s, "2b7e151628aed2a6abf7158809cf4f3c", 4)
+
+	var iv [BlockSize]byte
+	copy(iv[:], mustHex(t, "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff"))
+	pt := mustHex(t, "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417b")
+	ct, err := s.EncryptCTR(context.Background(), pt, iv, "127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := mustHex(t, "874d6191b620e3261bef6864990db6ce9806f66b7970fdff8617187bb9fffdff5ae4df3edbd5d35e5b4f09020db03eab1e031dda2fbe03d1792170a0")
+	if !bytes.Equal(ct, expected) {
+		t.Errorf("Expected %x, got %x", expected, ct)
+	}
+}
+
+// Decrypting the SP 800-38A F.5.2 vectors, whole and from an offset
+func TestDecryptCTR(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	iv := "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff"
+	ct := "874d6191b620e3261bef6864990db6ce9806f66b7970fdff8617187bb9fffdff5ae4df3edbd5d35e5b4f09020db03eab1e031dda2fbe03d1792170a0f3009cee"
+	pt := "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710"
+
+	port := startAES(t, s, "2b7e151628aed2a6abf7158809cf4f3c", 4)
+	blocks, err := s.AESCTRDecrypt(context.Background(), ct, "127.0.0.1", port, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(blocks, "") != strings.ToUpper(pt) {
+		t.Error("Expected", pt, "got", blocks)
+	}
+
+	// Bytes 20 to 50 are in the blocks 1 to 3, so 3 garblers are enough
+	port = startAES(t, s, "2b7e151628aed2a6abf7158809cf4f3c", 3)
+	part, err := s.DecryptCTRAt(context.Background(), mustHex(t, ct)[20:50], 20, [BlockSize]byte(mustHex(t, iv)), "127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(part, mustHex(t, pt)[20:50]) {
+		t.Errorf("Expected %x, got %x", mustHex(t, pt)[20:50], part)
+	}
+
+	// The same over a control session
+	b := controlBlock(t, "2b7e151628aed2a6abf7158809cf4f3c")
+	part, err = CTRDecryptAt(b, mustHex(t, ct)[20:50], 20, [BlockSize]byte(mustHex(t, iv)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(part, mustHex(t, pt)[20:50]) {
+		t.Errorf("Expected %x, got %x", mustHex(t, pt)[20:50], part)
+	}
+	whole, err := CTRDecrypt(b, mustHex(t, ct), [BlockSize]byte(mustHex(t, iv)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(whole, mustHex(t, pt)) {
+		t.Errorf("Expected %s, got %x", pt, whole)
+	}
+}
+
+func TestSplitData(t *testing.T) {
+	blocks := SplitData("0123456789", 4)
+	if len(blocks) != 3 || blocks[0] != "0123" || blocks[1] != "4567" || blocks[2] != "89" {
+		t.Error("Unexpected blocks", blocks)
+	}
+	if blocks := SplitData("01234567", 4); len(blocks) != 2 || blocks[1] != "4567" {
+		t.Error("Unexpected blocks", blocks)
+	}
+	// Split on bytes, a multibyte rune used to make it lose data
+	if blocks := SplitData("é0123", 2); len(blocks) != 3 || blocks[0] != "é" || blocks[2] != "23" {
+		t.Errorf("Unexpected blocks %q", blocks)
+	}
+	if blocks := SplitData("", 4); len(blocks) != 0 {
+		t.Error("Unexpected blocks", blocks)
+	}
+}
+
+func TestReverseBytes(t *testing.T) {
+	for _, v := range []struct{ in, out string }{{"", ""}, {"01", "01"}, {"0102", "0201"}, {"010203", "030201"}} {
+		b := mustHex(t, v.in)
+		reverseBytes(b, b)
+		if hex.EncodeToString(b) != v.out {
+			t.Errorf("Expected %s, got %x", v.out, b)
+		}
+	}
+}
+
+func TestAESKeyInput(t *testing.T) {
+	input, err := aesKeyInput(mustHex(t, "2b7e151628aed2a6abf7158809cf4f3c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input != "3c4fcf098815f7aba6d2ae2816157e2b" {
+		t.Error("Unexpected key input", input)
+	}
+	if _, err := aesKeyInput(make([]byte, 15)); err == nil {
+		t.Error("Expected an error for a short key")
+	}
+	for _, n := range []int{24, 32} {
+		if _, err := aesKeyInput(make([]byte, n)); err != nil {
+			t.Errorf("Unexpected error for a %d bytes key: %v", n, err)
+		}
+	}
+}
+
+// The SP 800-38A keys of each size
+var aesKeys = []string{
+	"2b7e151628aed2a6abf7158809cf4f3c",
+	"8e73b0f7da0e6452c810f32b809079e562f8ead2522c6b7b",
+	"603deb1015ca71be2b73aef0857d77811f352c073b6108d72d9810a30914dff4",
+}
+
+// Every mode gives the output of the standard library, whatever the key size
+func TestKeySizes(t *testing.T) {
+	iv := [BlockSize]byte(mustHex(t, "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff"))
+	plaintext := bytes.Repeat([]byte("AES-192 and AES-256 too."), 4)
+	for _, key := range aesKeys {
+		enc, _ := localBlocks(t, key, PadCTS)
+		enc.counter = Counter128
+		aesBlock, err := aes.NewCipher(mustHex(t, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := 8 * len(key) / 2
+
+		cbc, err := CBCEncrypt(enc, plaintext, iv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := make([]byte, len(plaintext))
+		cipher.NewCBCEncrypter(aesBlock, iv[:]).CryptBlocks(expected, plaintext)
+		if !bytes.Equal(cbc, expected) {
+			t.Errorf("AES-%d CBC: expected %x, got %x", size, expected, cbc)
+		}
+
+		for name, v := range map[string]struct {
+			stream cipher.Stream
+			mode   func() ([]byte, error)
+		}{
+			"CTR": {cipher.NewCTR(aesBlock, iv[:]), func() ([]byte, error) { return CTREncrypt(enc, plaintext, iv) }},
+			"CFB": {cipher.NewCFBEncrypter(aesBlock, iv[:]), func() ([]byte, error) { return feedbackCrypt(enc, modeCFB, plaintext, iv, false) }},
+			"OFB": {cipher.NewOFB(aesBlock, iv[:]), func() ([]byte, error) { return feedbackCrypt(enc, modeOFB, plaintext, iv, false) }},
+		} {
+			ct, err := v.mode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			v.stream.XORKeyStream(expected, plaintext)
+			if !bytes.Equal(ct, expected) {
+				t.Errorf("AES-%d %s: expected %x, got %x", size, name, expected, ct)
+			}
+		}
+
+		gcm, err := cipher.NewGCM(aesBlock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed, err := GCMSeal(enc, iv[:GCMNonceSize], plaintext, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := gcm.Seal(nil, iv[:GCMNonceSize], plaintext, nil); !bytes.Equal(sealed, expected) {
+			t.Errorf("AES-%d GCM: expected %x, got %x", size, expected, sealed)
+		}
+	}
+}
+
+// The key size is taken from the circuit, and Alice's key has to match it
+func TestCircuitKeySize(t *testing.T) {
+	for i, circuit := range []string{"aes_1cc.scd", "aes_192_1cc.scd", "aes_256_1cc.scd"} {
+		s := testSession(t, circuit)
+		key := mustHex(t, aesKeys[i])
+		if size, err := s.KeySize(); size != len(key) || err != nil {
+			t.Errorf("%s: expected a key size of %d, got %d, %v", circuit, len(key), size, err)
+		}
+		if err := s.RunServerKey(context.Background(), key[:len(key)-8], 1, 1); err == nil {
+			t.Errorf("%s: expected an error for a %d bytes key", circuit, len(key)-8)
+		}
+		if err := NewControlServer().HandleKey(circuit, s, append(key, key[:8]...)); err == nil {
+			t.Errorf("%s: expected an error for a %d bytes key", circuit, len(key)+8)
+		}
+
+		// The F.5.5 vector of SP 800-38A through the garblers for AES-256
+		if i != 2 {
+			continue
+		}
+		port := reservePorts(t, 1)
+		startGarbling(t, func(ctx context.Context) (*Garbler, error) {
+			return s.StartServer(ctx, ReverseEndianness(aesKeys[i]), port)
+		})
+		ct, err := s.EncryptCTR(context.Background(), mustHex(t, "6bc1bee22e409f96e93d7e117393172a"),
+			[BlockSize]byte(mustHex(t, "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")), "127.0.0.1", port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := "601ec313775789a5b7a7f504bbf3d228"; hex.EncodeToString(ct) != expected {
+			t.Errorf("Expected %s, got %x", expected, ct)
+		}
+	}
+
+	// Only TinyGarble's names tell the key size, the others need WithKeySize
+	for name, size := range map[string]int{"aes_dec_192_1cc.scd": 24, "aes_128_1cc.scd": 16, "aes_dec_1cc.scd": 16, "aes256_1cc.scd": 0, "my_aes_1cc.scd": 0, "hamming_32bit_1cc.scd": 0} {
+		got, err := aesKeySize(name)
+		if got != size || (err != nil) != (size == 0) {
+			t.Errorf("%s: expected a key size of %d, got %d, %v", name, size, got, err)
+		}
+	}
+	named, err := NewSession(WithBackend(ReferenceBackend{}), WithCircuit("aes256_1cc.scd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := named.RunServerKey(context.Background(), mustHex(t, aesKeys[2]), 1, 1); err == nil || !strings.Contains(err.Error(), "WithKeySize") {
+		t.Error("Expected an error asking for WithKeySize, got", err)
+	}
+	named, err = NewSession(WithBackend(ReferenceBackend{}), WithCircuit("aes256_1cc.scd"), WithKeySize(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, err := named.KeySize(); size != 32 || err != nil {
+		t.Errorf("Expected a key size of 32, got %d, %v", size, err)
+	}
+	if _, err := NewSession(WithBackend(ReferenceBackend{}), WithCircuit("aes_1cc.scd"), WithKeySize(20)); err == nil {
+		t.Error("Expected an error for a 20 bytes key size")
+	}
+
+	// The reference circuits don't take a key of another size
+	s := testSession(t, "aes_256_1cc.scd")
+	port := startAES(t, s, aesKeys[0], 1)
+	if _, err := s.EncryptCTR(context.Background(), make([]byte, BlockSize), [BlockSize]byte{}, "127.0.0.1", port); err == nil {
+		t.Error("Expected an error for a 128 bits key with the AES-256 circuit")
+	}
+}
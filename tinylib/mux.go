@@ -0,0 +1,394 @@
+package tinylib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// The multiplexer carries several byte streams over the single TCP connection of a tunnelled control session: the
+// stream 0 carries the control messages, and Bob opens one stream per evaluation, which Alice connects to the garbler
+// listening on her loopback. Every frame is made of a 7 bytes header, its type, stream id and payload length, followed
+// by the payload.
+//
+// Each end of a stream may only send as many bytes as the other end granted it, streamWindow at first, and the reader
+// grants more once it has read them, so that a stream never buffers more than streamWindow bytes. An end sending more
+// than that, e.g. a client who isn't Bob trying to fill Alice's memory, gets the whole connection closed.
+const (
+	// Opens a stream to the garbler whose port is given as a 2 bytes payload
+	frameOpen byte = iota
+	frameData
+	// The sender won't write on the stream anymore
+	frameClose
+	// The sender may receive as many more bytes as the 4 bytes payload tells
+	frameWindow
+	// The sender closed the stream, and won't read it anymore
+	frameReset
+)
+
+const frameHeaderLen = 7
+
+// The largest payload we send in one frame
+const maxFramePayload = 32 * 1024
+
+// The most bytes a stream buffers before they are read
+const streamWindow = 256 * 1024
+
+// ErrTunnelClosed is returned when using a stream whose tunnel connection has been closed
+var ErrTunnelClosed = errors.New("tinylib: tunnel closed")
+
+// One end of a multiplexed connection
+type mux struct {
+	conn net.Conn
+	r    io.Reader
+
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	streams map[uint32]*muxStream
+	nextID  uint32
+
+	// The streams opened by the other end, nil if it may not open any
+	accept chan *muxStream
+	done   chan struct{}
+}
+
+// Starts multiplexing over the connection, reading from r which may be a buffered reader of the connection. Only Alice
+// accepts the streams opened by the other end: Bob's end fails if Alice tries to open one, rather than waiting for ever
+// for someone to accept it.
+func newMux(conn net.Conn, r io.Reader, accept bool) *mux {
+	m := &mux{
+		conn:    conn,
+		r:       r,
+		streams: make(map[uint32]*muxStream),
+		nextID:  1,
+		done:    make(chan struct{}),
+	}
+	if accept {
+		m.accept = make(chan *muxStream)
+	}
+	m.streams[0] = newMuxStream(m, 0)
+	go m.readLoop()
+	return m
+}
+
+// The stream carrying the control messages
+func (m *mux) control() *muxStream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.streams[0]
+}
+
+// Dispatches the frames to their streams, until the connection fails or is closed
+func (m *mux) readLoop() {
+	err := m.readFrames()
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		err = ErrTunnelClosed
+	}
+	close(m.done)
+	m.mu.Lock()
+	streams := m.streams
+	m.streams = nil
+	m.mu.Unlock()
+	for _, s := range streams {
+		s.fail(err)
+	}
+}
+
+func (m *mux) readFrames() error {
+	hdr := make([]byte, frameHeaderLen)
+	for {
+		if _, err := io.ReadFull(m.r, hdr); err != nil {
+			return err
+		}
+		typ, id := hdr[0], binary.BigEndian.Uint32(hdr[1:5])
+		payload := make([]byte, binary.BigEndian.Uint16(hdr[5:7]))
+		if _, err := io.ReadFull(m.r, payload); err != nil {
+			return err
+		}
+
+		switch typ {
+		case frameOpen:
+			if m.accept == nil {
+				return fmt.Errorf("tinylib: the other end can't open streams, it opened stream %d", id)
+			}
+			if len(payload) != 2 {
+				return fmt.Errorf("tinylib: invalid open frame for stream %d", id)
+			}
+			m.mu.Lock()
+			if _, ok := m.streams[id]; ok || id == 0 {
+				m.mu.Unlock()
+				return fmt.Errorf("tinylib: stream %d opened twice", id)
+			}
+			s := newMuxStream(m, id)
+			s.target = int(binary.BigEndian.Uint16(payload))
+			m.streams[id] = s
+			m.mu.Unlock()
+			select {
+			case m.accept <- s:
+			case <-m.done:
+			}
+		case frameData, frameClose, frameWindow, frameReset:
+			// Frames for a stream we closed already are simply dropped
+			s := m.stream(id)
+			if s == nil {
+				continue
+			}
+			switch typ {
+			case frameData:
+				if err := s.push(payload); err != nil {
+					return err
+				}
+			case frameClose:
+				s.pushEOF()
+			case frameWindow:
+				if len(payload) != 4 {
+					return fmt.Errorf("tinylib: invalid window frame for stream %d", id)
+				}
+				s.grant(int(binary.BigEndian.Uint32(payload)))
+			case frameReset:
+				s.reset()
+			}
+		default:
+			return fmt.Errorf("tinylib: unknown frame type %d", typ)
+		}
+	}
+}
+
+func (m *mux) stream(id uint32) *muxStream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.streams[id]
+}
+
+func (m *mux) remove(id uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.streams != nil {
+		delete(m.streams, id)
+	}
+}
+
+func (m *mux) writeFrame(typ byte, id uint32, payload []byte) error {
+	frame := make([]byte, frameHeaderLen, frameHeaderLen+len(payload))
+	frame[0] = typ
+	binary.BigEndian.PutUint32(frame[1:5], id)
+	binary.BigEndian.PutUint16(frame[5:7], uint16(len(payload)))
+	frame = append(frame, payload...)
+
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	if _, err := m.conn.Write(frame); err != nil {
+		return fmt.Errorf("%w: %v", ErrTunnelClosed, err)
+	}
+	return nil
+}
+
+// Opens a new stream, which the other end connects to the garbler listening on the given port of its loopback
+func (m *mux) open(port int) (*muxStream, error) {
+	m.mu.Lock()
+	if m.streams == nil {
+		m.mu.Unlock()
+		return nil, ErrTunnelClosed
+	}
+	id := m.nextID
+	m.nextID++
+	s := newMuxStream(m, id)
+	m.streams[id] = s
+	m.mu.Unlock()
+
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(port))
+	if err := m.writeFrame(frameOpen, id, payload); err != nil {
+		m.remove(id)
+		return nil, err
+	}
+	return s, nil
+}
+
+// A stream of a mux. The data received is buffered until it is read, at most streamWindow bytes of it.
+type muxStream struct {
+	m      *mux
+	id     uint32
+	target int
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	buf         bytes.Buffer
+	eof         bool
+	err         error
+	closed      bool
+	writeClosed bool
+	// The other end closed the stream, writing to it is pointless
+	peerClosed bool
+	// How many bytes we may still send, and how many we read but didn't grant back to the other end yet
+	sendWindow int
+	unacked    int
+}
+
+func newMuxStream(m *mux, id uint32) *muxStream {
+	s := &muxStream{m: m, id: id, sendWindow: streamWindow}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Buffers the data received, failing if the other end sent more than we granted it
+func (s *muxStream) push(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	if s.buf.Len()+s.unacked+len(p) > streamWindow {
+		return fmt.Errorf("tinylib: stream %d received more than its window", s.id)
+	}
+	s.buf.Write(p)
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *muxStream) grant(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendWindow += n
+	s.cond.Broadcast()
+}
+
+func (s *muxStream) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peerClosed = true
+	s.cond.Broadcast()
+}
+
+func (s *muxStream) pushEOF() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.eof = true
+	s.cond.Broadcast()
+}
+
+func (s *muxStream) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+}
+
+func (s *muxStream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	for s.buf.Len() == 0 && !s.eof && s.err == nil && !s.closed {
+		s.cond.Wait()
+	}
+	if s.buf.Len() == 0 {
+		defer s.mu.Unlock()
+		if s.closed {
+			return 0, net.ErrClosed
+		}
+		if s.eof {
+			return 0, io.EOF
+		}
+		return 0, s.err
+	}
+	n, _ := s.buf.Read(p)
+	// The window is granted back by halves, rather than for every read
+	s.unacked += n
+	grant := 0
+	if s.unacked >= streamWindow/2 && !s.eof {
+		grant, s.unacked = s.unacked, 0
+	}
+	s.mu.Unlock()
+	if grant > 0 {
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, uint32(grant))
+		if err := s.m.writeFrame(frameWindow, s.id, payload); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (s *muxStream) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// Waiting for the other end to let us send more
+		s.mu.Lock()
+		for s.sendWindow == 0 && !s.writeClosed && !s.closed && !s.peerClosed && s.err == nil {
+			s.cond.Wait()
+		}
+		if s.writeClosed || s.closed || s.peerClosed {
+			s.mu.Unlock()
+			return written, net.ErrClosed
+		}
+		if s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			return written, err
+		}
+		n := min(len(p), maxFramePayload, s.sendWindow)
+		s.sendWindow -= n
+		s.mu.Unlock()
+
+		if err := s.m.writeFrame(frameData, s.id, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// CloseWrite tells the other end we won't write anymore, it then reads io.EOF
+func (s *muxStream) CloseWrite() error {
+	s.mu.Lock()
+	if s.writeClosed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.writeClosed = true
+	s.mu.Unlock()
+	return s.m.writeFrame(frameClose, s.id, nil)
+}
+
+// Close stops both directions, the data not read yet is dropped, and the other end can't write to the stream anymore
+func (s *muxStream) Close() error {
+	err := s.CloseWrite()
+	s.mu.Lock()
+	alreadyClosed := s.closed
+	s.closed = true
+	s.buf.Reset()
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.m.remove(s.id)
+	if !alreadyClosed {
+		if rerr := s.m.writeFrame(frameReset, s.id, nil); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// Copies the bytes both ways between a TCP connection and a stream, until both directions are done
+func pipe(conn *net.TCPConn, s *muxStream) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(s, conn)
+		s.CloseWrite()
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(conn, s)
+		conn.CloseWrite()
+	}()
+	wg.Wait()
+	conn.Close()
+	s.Close()
+}
@@ -0,0 +1,165 @@
+package tinylib
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Two ends of a mux over a local TCP connection
+func muxPair(t *testing.T) (*mux, *mux) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return newMux(client, client, false), newMux(server, server, true)
+}
+
+func TestMuxStreams(t *testing.T) {
+	bob, alice := muxPair(t)
+
+	// More than one frame of data, on two interleaved streams
+	data := bytes.Repeat([]byte("garbled tables "), 10000)
+	s1, err := bob.open(1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := bob.open(1235)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		s2.Write([]byte("second"))
+		s2.CloseWrite()
+		s1.Write(data)
+		s1.CloseWrite()
+	}()
+
+	a1 := <-alice.accept
+	a2 := <-alice.accept
+	if a1.target != 1234 || a2.target != 1235 {
+		t.Error("Unexpected targets", a1.target, a2.target)
+	}
+	got, err := io.ReadAll(a2)
+	if err != nil || string(got) != "second" {
+		t.Error("Unexpected second stream", string(got), err)
+	}
+	got, err = io.ReadAll(a1)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Error("Unexpected first stream of", len(got), "bytes", err)
+	}
+
+	// The control stream works both ways
+	go alice.control().Write([]byte("ok"))
+	buf := make([]byte, 2)
+	if _, err = io.ReadFull(bob.control(), buf); err != nil || string(buf) != "ok" {
+		t.Error("Unexpected control message", string(buf), err)
+	}
+
+	// Closing the connection fails the streams
+	bob.conn.Close()
+	if _, err = alice.control().Read(buf); !errors.Is(err, ErrTunnelClosed) {
+		t.Error("Expected ErrTunnelClosed, got", err)
+	}
+}
+
+// A stream carries more than its window once the reader grants it more, and the writer waits for it
+func TestMuxFlowControl(t *testing.T) {
+	bob, alice := muxPair(t)
+	s, err := bob.open(1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4*streamWindow/16)
+	written := make(chan error, 1)
+	go func() {
+		_, err := s.Write(data)
+		s.CloseWrite()
+		written <- err
+	}()
+
+	a := <-alice.accept
+	select {
+	case err := <-written:
+		t.Fatal("The writer didn't wait for its window, got", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	a.mu.Lock()
+	buffered := a.buf.Len()
+	a.mu.Unlock()
+	if buffered > streamWindow {
+		t.Error("Buffered", buffered, "bytes, more than the window")
+	}
+	got, err := io.ReadAll(a)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Error("Unexpected stream of", len(got), "bytes", err)
+	}
+	if err := <-written; err != nil {
+		t.Error(err)
+	}
+
+	// Once the reader closed the stream, the writer fails rather than waiting for ever
+	s, err = bob.open(1235)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a = <-alice.accept
+	a.Close()
+	if _, err := s.Write(data); !errors.Is(err, net.ErrClosed) {
+		t.Error("Expected net.ErrClosed, got", err)
+	}
+}
+
+// An end ignoring its window gets the connection closed
+func TestMuxWindowOverflow(t *testing.T) {
+	bob, alice := muxPair(t)
+	s, err := bob.open(1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-alice.accept
+	payload := make([]byte, maxFramePayload)
+	for sent := 0; sent <= streamWindow; sent += len(payload) {
+		if err := bob.writeFrame(frameData, s.id, payload); err != nil {
+			break
+		}
+	}
+	select {
+	case <-alice.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The tunnel wasn't closed")
+	}
+}
+
+// Alice opening a stream to Bob closes the tunnel, instead of blocking Bob's read loop for ever
+func TestMuxOpenFromAlice(t *testing.T) {
+	bob, alice := muxPair(t)
+	control := bob.control()
+	if err := alice.writeFrame(frameOpen, 1, []byte{0x04, 0xd2}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-bob.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The tunnel wasn't closed")
+	}
+	if _, err := control.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the control stream to fail")
+	}
+}
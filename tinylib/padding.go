@@ -0,0 +1,69 @@
+package tinylib
+
+import (
+	"crypto/subtle"
+	"fmt"
+)
+
+// Fills the end of the block, from n on, with the padding. n has to be less than BlockSize, so that there is always
+// at least one byte of padding.
+func padBlock(block []byte, n int, p Padding) {
+	switch p {
+	case PadPKCS7:
+		for i := n; i < BlockSize; i++ {
+			block[i] = byte(BlockSize - n)
+		}
+	case PadISO7816:
+		block[n] = 0x80
+		clear(block[n+1:])
+	}
+}
+
+// Removes the padding of the decrypted data, returning ErrInvalidPadding if it isn't valid. The checks run in constant
+// time, so that the time taken doesn't tell how the padding is wrong.
+func unpad(data []byte, p Padding) ([]byte, error) {
+	if len(data) == 0 || len(data)%BlockSize != 0 {
+		return nil, fmt.Errorf("%w: %d bytes isn't a whole number of blocks", ErrInvalidPadding, len(data))
+	}
+	last := data[len(data)-BlockSize:]
+	var good, n int
+	switch p {
+	case PadPKCS7:
+		good, n = pkcs7Length(last)
+	case PadISO7816:
+		good, n = iso7816Length(last)
+	default:
+		return data, nil
+	}
+	if good != 1 {
+		return nil, ErrInvalidPadding
+	}
+	return data[:len(data)-n], nil
+}
+
+// The length of the PKCS#7 padding of the block, good being 1 if it is valid: n bytes of value n, n being at least 1
+func pkcs7Length(last []byte) (good, n int) {
+	n = int(last[BlockSize-1])
+	good = subtle.ConstantTimeLessOrEq(1, n) & subtle.ConstantTimeLessOrEq(n, BlockSize)
+	for i := 0; i < BlockSize; i++ {
+		// The i-th byte from the end has to be n if it is part of the padding
+		inPadding := subtle.ConstantTimeLessOrEq(i+1, n)
+		good &= (1 ^ inPadding) | subtle.ConstantTimeByteEq(last[BlockSize-1-i], byte(n))
+	}
+	return good, n
+}
+
+// The length of the ISO/IEC 7816-4 padding of the block, good being 1 if it is valid: a 0x80 byte followed by 0's
+func iso7816Length(last []byte) (good, n int) {
+	found := 0
+	good = 1
+	for i := BlockSize - 1; i >= 0; i-- {
+		zero := subtle.ConstantTimeByteEq(last[i], 0)
+		marker := (1 ^ found) & subtle.ConstantTimeByteEq(last[i], 0x80)
+		// Until we find the marker, there must only be 0's
+		good &= found | zero | marker
+		n = subtle.ConstantTimeSelect(marker, BlockSize-i, n)
+		found |= marker
+	}
+	return good & found, n
+}
@@ -0,0 +1,148 @@
+package tinylib
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"testing"
+)
+
+// A peer running a reference circuit locally with Alice's key
+type localPeer struct {
+	key string
+	f   referenceFunction
+}
+
+func (l localPeer) evaluate(ctx context.Context, data string) (string, error) {
+	return l.f(l.key, data)
+}
+
+// Blocks encrypting and decrypting with the given key and padding, without any garbler
+func localBlocks(t *testing.T, key string, padding Padding) (enc, dec *GarbledBlock) {
+	input, err := aesKeyInput(mustHex(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := len(key) / 2
+	enc = &GarbledBlock{ctx: context.Background(), p: localPeer{input, referenceAESSized(size, false)}, padding: padding}
+	dec = &GarbledBlock{ctx: context.Background(), p: localPeer{input, referenceAESSized(size, true)}, padding: padding}
+	return enc, dec
+}
+
+// Both paddings give the standard library CBC of the padded data, whatever the length, and decrypt back
+func TestPaddingRoundTrip(t *testing.T) {
+	key := "2b7e151628aed2a6abf7158809cf4f3c"
+	aesBlock, err := aes.NewCipher(mustHex(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := [BlockSize]byte(mustHex(t, "000102030405060708090a0b0c0d0e0f"))
+	message := []byte("Short messages are fine with a padding.")
+	for _, v := range []struct {
+		padding Padding
+		pad     func(n int) []byte
+	}{
+		{PadPKCS7, func(n int) []byte { return bytes.Repeat([]byte{byte(n)}, n) }},
+		{PadISO7816, func(n int) []byte { return append([]byte{0x80}, make([]byte, n-1)...) }},
+	} {
+		enc, dec := localBlocks(t, key, v.padding)
+		for _, n := range []int{0, 1, 5, 15, 16, 17, 32, 39} {
+			pt := message[:n]
+			ct, err := CBCEncrypt(enc, pt, iv)
+			if err != nil {
+				t.Fatalf("%v, %d bytes: %v", v.padding, n, err)
+			}
+			padded := append(bytes.Clone(pt), v.pad(BlockSize-n%BlockSize)...)
+			expected := make([]byte, len(padded))
+			cipher.NewCBCEncrypter(aesBlock, iv[:]).CryptBlocks(expected, padded)
+			if !bytes.Equal(ct, expected) {
+				t.Errorf("%v, %d bytes: expected %x, got %x", v.padding, n, expected, ct)
+			}
+			decrypted, err := CBCDecrypt(dec, ct, iv)
+			if err != nil {
+				t.Fatalf("%v, %d bytes: %v", v.padding, n, err)
+			}
+			if !bytes.Equal(decrypted, pt) {
+				t.Errorf("%v, %d bytes: expected %q, got %q", v.padding, n, pt, decrypted)
+			}
+		}
+	}
+}
+
+// Every wrong padding gives ErrInvalidPadding
+func TestUnpad(t *testing.T) {
+	block := func(tail ...byte) []byte {
+		b := bytes.Repeat([]byte{0xaa}, BlockSize)
+		return append(b[:BlockSize-len(tail)], tail...)
+	}
+	for _, v := range []struct {
+		padding Padding
+		data    []byte
+		// The length of the padding, -1 if it is invalid
+		n int
+	}{
+		{PadPKCS7, block(1), 1},
+		{PadPKCS7, block(3, 3, 3), 3},
+		{PadPKCS7, bytes.Repeat([]byte{16}, 16), 16},
+		{PadPKCS7, block(0), -1},
+		{PadPKCS7, block(17), -1},
+		{PadPKCS7, block(2, 3, 3), -1},
+		{PadPKCS7, block(3, 2, 3), -1},
+		{PadPKCS7, bytes.Repeat([]byte{16}, 15), -1},
+		{PadPKCS7, nil, -1},
+		{PadISO7816, block(0x80), 1},
+		{PadISO7816, block(0x80, 0, 0), 3},
+		{PadISO7816, append([]byte{0x80}, make([]byte, 15)...), 16},
+		{PadISO7816, block(0x80, 0x80), 1},
+		{PadISO7816, block(0x80, 0, 1), -1},
+		{PadISO7816, block(0), -1},
+		{PadISO7816, make([]byte, 16), -1},
+		{PadISO7816, block(0x81), -1},
+	} {
+		out, err := unpad(v.data, v.padding)
+		if v.n < 0 {
+			if !errors.Is(err, ErrInvalidPadding) {
+				t.Errorf("%v %x: expected ErrInvalidPadding, got %v", v.padding, v.data, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v %x: %v", v.padding, v.data, err)
+		} else if len(out) != len(v.data)-v.n {
+			t.Errorf("%v %x: expected %d bytes of padding, got %d", v.padding, v.data, v.n, len(v.data)-len(out))
+		}
+	}
+
+	_, dec := localBlocks(t, "2b7e151628aed2a6abf7158809cf4f3c", PadPKCS7)
+	if _, err := CBCDecrypt(dec, make([]byte, 20), [BlockSize]byte{}); !errors.Is(err, ErrInvalidPadding) {
+		t.Error("Expected ErrInvalidPadding for a partial block, got", err)
+	}
+	if _, err := NewSession(WithBackend(ReferenceBackend{}), WithCircuit("aes_1cc.scd"), WithPadding(3)); err == nil {
+		t.Error("Expected an error for an invalid padding")
+	}
+}
+
+// A message shorter than a block through the garblers, with the hexadecimal API
+func TestAESCBCPadding(t *testing.T) {
+	key := "2b7e151628aed2a6abf7158809cf4f3c"
+	enc := testSession(t, "aes_1cc.scd", WithPadding(PadPKCS7))
+	dec := testSession(t, "aes_dec_1cc.scd", WithPadding(PadPKCS7))
+	ctx := context.Background()
+
+	cipher, iv, err := enc.AESCBC(ctx, "0011223344", "127.0.0.1", startAES(t, enc, key, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cipher) != 1 || len(cipher[0]) != 32 {
+		t.Fatal("Expected one block, got", cipher)
+	}
+	plain, err := dec.AESCBCDecrypt(ctx, cipher[0], "127.0.0.1", startAES(t, dec, key, 1), iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plain) != 1 || plain[0] != "0011223344" {
+		t.Error("Expected [0011223344], got", plain)
+	}
+}
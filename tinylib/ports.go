@@ -0,0 +1,131 @@
+package tinylib
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultQuarantine is how long a released port isn't handed out again, which is the TIME_WAIT duration on Linux.
+// TinyGarble doesn't set SO_REUSEADDR, so it fails with exit status 255 on a port still having a socket in TIME_WAIT.
+const DefaultQuarantine = 60 * time.Second
+
+// PortManager hands out ports of a given range to the servers, and to the clients which have to reach them, so that
+// one doesn't have to pick random ports and rerun when they happen to be in use. A port is only handed out if nobody
+// listens on it and it has no socket in TIME_WAIT; once released, it is kept aside for a while before being reused.
+// A PortManager is safe for concurrent use, but it only knows about the ports of its own process: two programs should
+// use distinct ranges. The range should also be outside of the ephemeral ports of the system (32768-60999 on Linux, see
+// /proc/sys/net/ipv4/ip_local_port_range), or an outgoing connection may take a port before its garbler listens on it.
+type PortManager struct {
+	first, last int
+	quarantine  time.Duration
+
+	mu       sync.Mutex
+	next     int
+	reserved map[int]bool
+	released map[int]time.Time
+}
+
+// NewPortManager returns a PortManager handing out the ports between first and last, included. The released ports
+// aren't handed out again before the quarantine is over, see DefaultQuarantine.
+func NewPortManager(first, last int, quarantine time.Duration) (*PortManager, error) {
+	if first < 1 || last > 65535 || first > last || quarantine < 0 {
+		return nil, fmt.Errorf("tinylib: invalid port range %d-%d, quarantine %v", first, last, quarantine)
+	}
+	return &PortManager{
+		first:      first,
+		last:       last,
+		quarantine: quarantine,
+		next:       first,
+		reserved:   make(map[int]bool),
+		released:   make(map[int]time.Time),
+	}, nil
+}
+
+// Reserve returns a free port, which stays reserved until it is given back to Release.
+// It returns ErrPortsExhausted if there is no free port left in the range.
+func (pm *PortManager) Reserve() (int, error) {
+	return pm.ReserveRange(1)
+}
+
+// ReserveRange reserves n consecutive free ports and returns the first one, as needed by the fixed schedule of
+// RunServer and Session.AESCBC, which use one port per block. It returns ErrPortsExhausted if there are no such ports.
+func (pm *PortManager) ReserveRange(n int) (int, error) {
+	if n < 1 {
+		return 0, fmt.Errorf("tinylib: invalid number of ports %d", n)
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	// We go round the range starting after the last ports handed out, so that a port is reused as late as possible
+	size := pm.last - pm.first + 1
+	for i := 0; i < size; i++ {
+		start := pm.first + (pm.next-pm.first+i)%size
+		if start+n-1 > pm.last {
+			continue
+		}
+		if !pm.free(start, n) {
+			continue
+		}
+		for port := start; port < start+n; port++ {
+			pm.reserved[port] = true
+			delete(pm.released, port)
+		}
+		pm.next = start + n
+		if pm.next > pm.last {
+			pm.next = pm.first
+		}
+		return start, nil
+	}
+	return 0, fmt.Errorf("%w: no %d consecutive ports in %d-%d", ErrPortsExhausted, n, pm.first, pm.last)
+}
+
+// Release gives back ports handed out by Reserve or ReserveRange, they are quarantined before being reused.
+func (pm *PortManager) Release(ports ...int) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	now := time.Now()
+	for _, port := range ports {
+		if pm.reserved[port] {
+			delete(pm.reserved, port)
+			pm.released[port] = now
+		}
+	}
+}
+
+// ReleaseRange gives back the n consecutive ports starting at first, as returned by ReserveRange. It does nothing if n
+// is less than 1, as ReserveRange never hands out such a range.
+func (pm *PortManager) ReleaseRange(first, n int) {
+	if n < 1 {
+		return
+	}
+	ports := make([]int, n)
+	for i := range ports {
+		ports[i] = first + i
+	}
+	pm.Release(ports...)
+}
+
+// Tells whether the n ports starting at start can be handed out, must be called with the lock held
+func (pm *PortManager) free(start, n int) bool {
+	now := time.Now()
+	for port := start; port < start+n; port++ {
+		if pm.reserved[port] {
+			return false
+		}
+		if at, ok := pm.released[port]; ok {
+			if now.Sub(at) < pm.quarantine {
+				return false
+			}
+			delete(pm.released, port)
+		}
+		// Someone else might have closed a connection on it recently
+		if wait, _ := timeWait(port); wait {
+			return false
+		}
+		if checkPort(port) != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package tinylib
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Builds a PortManager over ports the system gave us, so that they are very likely free
+func testPortManager(t *testing.T, n int, quarantine time.Duration) (*PortManager, int) {
+	first, err := testPorts.ReserveRange(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { testPorts.ReleaseRange(first, n) })
+	pm, err := NewPortManager(first, first+n-1, quarantine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pm, first
+}
+
+func TestNewPortManager(t *testing.T) {
+	for _, r := range [][2]int{{0, 10}, {10, 9}, {65535, 65536}} {
+		if _, err := NewPortManager(r[0], r[1], 0); err == nil {
+			t.Error("Expected an error for the range", r)
+		}
+	}
+}
+
+// Every port is handed out once, then the manager is exhausted
+func TestPortManagerExhaustion(t *testing.T) {
+	pm, first := testPortManager(t, 3, DefaultQuarantine)
+
+	seen := make(map[int]bool)
+	for i := 0; i < 3; i++ {
+		port, err := pm.Reserve()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port < first || port > first+2 || seen[port] {
+			t.Error("Unexpected port", port)
+		}
+		seen[port] = true
+	}
+	if _, err := pm.Reserve(); !errors.Is(err, ErrPortsExhausted) {
+		t.Error("Expected ErrPortsExhausted, got", err)
+	}
+	if _, err := pm.ReserveRange(0); err == nil || errors.Is(err, ErrPortsExhausted) {
+		t.Error("Expected an invalid number of ports error, got", err)
+	}
+	// Empty or negative ranges are ignored, as ReserveRange rejects them
+	pm.ReleaseRange(first, 0)
+	pm.ReleaseRange(first, -1)
+	if _, err := pm.Reserve(); !errors.Is(err, ErrPortsExhausted) {
+		t.Error("Expected ErrPortsExhausted, got", err)
+	}
+}
+
+// A released port isn't handed out again before its quarantine is over
+func TestPortManagerQuarantine(t *testing.T) {
+	pm, _ := testPortManager(t, 1, 100*time.Millisecond)
+
+	port, err := pm.Reserve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.Release(port)
+	if _, err := pm.Reserve(); !errors.Is(err, ErrPortsExhausted) {
+		t.Error("Expected ErrPortsExhausted during the quarantine, got", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	again, err := pm.Reserve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != port {
+		t.Error("Expected", port, "got", again)
+	}
+}
+
+// Ports someone listens on are skipped, and ReserveRange only returns consecutive free ports
+func TestPortManagerSkipsBusy(t *testing.T) {
+	pm, first := testPortManager(t, 4, 0)
+
+	l, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(first+1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	port, err := pm.ReserveRange(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != first+2 {
+		t.Error("Expected", first+2, "got", port)
+	}
+	if _, err := pm.ReserveRange(2); !errors.Is(err, ErrPortsExhausted) {
+		t.Error("Expected ErrPortsExhausted, got", err)
+	}
+	if port, err := pm.Reserve(); err != nil || port != first {
+		t.Error("Expected", first, "got", port, err)
+	}
+}
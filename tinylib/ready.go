@@ -0,0 +1,96 @@
+package tinylib
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often we look for the garbler's listening socket
+const readyPoll = 5 * time.Millisecond
+
+// How long we wait for a garbler when we can't tell whether it listens, that's what the tests used to sleep
+const readyFallback = 100 * time.Millisecond
+
+// The states of the sockets in /proc/net/tcp we care about
+const (
+	tcpTimeWait = "06"
+	tcpListen   = "0A"
+)
+
+// Tells whether something is listening on the given TCP port of this host. We can't simply connect to the port to find
+// out, since TinyGarble would take us for Bob, so this reads /proc/net/tcp and /proc/net/tcp6. ok is false if we can't tell.
+func listening(port int) (listen bool, ok bool) {
+	return portInState(port, tcpListen)
+}
+
+// Tells whether a socket on the given local port is in TIME_WAIT, which an upstream TinyGarble can't bind over since
+// it doesn't set SO_REUSEADDR. ok is false if we can't tell.
+func timeWait(port int) (wait bool, ok bool) {
+	return portInState(port, tcpTimeWait)
+}
+
+// Looks for a socket on the local port in the given state, in both /proc/net/tcp and /proc/net/tcp6
+func portInState(port int, state string) (found bool, ok bool) {
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		found, err := portInStateIn(table, port, state)
+		if err != nil {
+			continue
+		}
+		ok = true
+		if found {
+			return true, true
+		}
+	}
+	return false, ok
+}
+
+// Looks for a socket on the port in the given state in one of the /proc/net tables
+func portInStateIn(table string, port int, state string) (bool, error) {
+	f, err := os.Open(table)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // the header
+	for scanner.Scan() {
+		// sl local_address rem_address st ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[3] != state {
+			continue
+		}
+		i := strings.LastIndexByte(fields[1], ':')
+		p, err := strconv.ParseUint(fields[1][i+1:], 16, 16)
+		if err == nil && int(p) == port {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// Waits until something listens on the port of this host. It returns an error if the garbler stops before (done is
+// closed) or if the context is done. When we can't tell whether the port is listening, we just wait for a while.
+func waitListening(ctx context.Context, port int, done <-chan struct{}) error {
+	ticker := time.NewTicker(readyPoll)
+	defer ticker.Stop()
+	deadline := time.Now().Add(readyFallback)
+	for {
+		listen, ok := listening(port)
+		if listen || (!ok && time.Now().After(deadline)) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+			return errors.New("tinylib: the garbler stopped before listening")
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package tinylib
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReferenceBackend computes in the clear, in the Go process, the same functions as the TinyGarble circuits it knows:
+// aes_1cc.scd, using crypto/aes with the same little endian convention as TinyGarble (see checks/checks.go), its
+// AES-192 and AES-256 counterparts aes_192_1cc.scd and aes_256_1cc.scd, the decryption ones aes_dec_1cc.scd,
+// aes_dec_192_1cc.scd and aes_dec_256_1cc.scd, and
+// hamming_32bit_1cc.scd or hamming_32bit_8cc.scd, whose output is the number of bits differing between Alice and Bob.
+//
+// It keeps the Alice/Bob rendezvous over TCP on the given ports, so that the modes of operation and the port handling
+// can be tested without TinyGarble. It is of course not secure at all: Bob simply sends his input to Alice.
+type ReferenceBackend struct{}
+
+// A function computed by a circuit, on hexadecimal inputs, as TinyGarble would output it
+type referenceFunction func(alice string, bob string) (string, error)
+
+// Garble waits on the given port for one Bob, computes the circuit output and sends it back to him. It listens on every
+// interface, or only on 127.0.0.1 when LoopbackOnly(ctx) is set. It returns ErrPortUnavailable if it can't listen on the port.
+func (b ReferenceBackend) Garble(ctx context.Context, c Circuit, input string, port int) error {
+	return b.GarbleReady(ctx, c, input, port, func() {})
+}
+
+// GarbleReady works as Garble, calling ready as soon as it listens on the port.
+func (ReferenceBackend) GarbleReady(ctx context.Context, c Circuit, input string, port int, ready func()) error {
+	f, err := referenceCircuit(c)
+	if err != nil {
+		return err
+	}
+
+	host := ""
+	if LoopbackOnly(ctx) {
+		host = "127.0.0.1"
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("%w: %d: %v", ErrPortUnavailable, port, err)
+	}
+	defer l.Close()
+	// Closing the listener or the connection is what unblocks us when the context is done
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+	ready()
+
+	conn, err := l.Accept()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	defer conn.Close()
+	stopConn := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stopConn()
+
+	bob, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("tinylib: reading Bob's input: %w", err)
+	}
+	out, err := f(strings.TrimSpace(input), strings.TrimSpace(bob))
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(conn, out+"\n")
+	return err
+}
+
+// Evaluate connects to Alice on addr:port, sends her Bob's input and returns the output of the circuit, ending with a
+// new line as TinyGarble's does.
+func (ReferenceBackend) Evaluate(ctx context.Context, c Circuit, input string, addr string, port int) (string, error) {
+	if _, err := referenceCircuit(c); err != nil {
+		return "", err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(addr, strconv.Itoa(port)))
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("%w: %v", ErrConnectFailed, err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if _, err = io.WriteString(conn, input+"\n"); err != nil {
+		return "", err
+	}
+	out, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", errors.New("tinylib: Alice closed the connection without sending the output")
+	}
+	return out, nil
+}
+
+// Finds the function computed by the given circuit, using the name of its file
+func referenceCircuit(c Circuit) (referenceFunction, error) {
+	size, _ := aesKeySize(c.Path)
+	switch filepath.Base(c.Path) {
+	case "aes_1cc.scd", "aes_192_1cc.scd", "aes_256_1cc.scd":
+		return referenceAESSized(size, false), nil
+	case "aes_dec_1cc.scd", "aes_dec_192_1cc.scd", "aes_dec_256_1cc.scd":
+		return referenceAESSized(size, true), nil
+	case "hamming_32bit_1cc.scd", "hamming_32bit_8cc.scd":
+		return referenceHamming, nil
+	}
+	return nil, fmt.Errorf("tinylib: the reference backend doesn't know the circuit %q", c.Path)
+}
+
+// AES whose key has to have the size of the circuit, as for a real one whose input has a fixed number of bits
+func referenceAESSized(size int, decrypt bool) referenceFunction {
+	return func(alice string, bob string) (string, error) {
+		if len(alice) != 2*size {
+			return "", fmt.Errorf("%w: the circuit takes a %d bits key, got %d bits", ErrInvalidHex, 8*size, 4*len(alice))
+		}
+		return referenceAESBlock(alice, bob, decrypt)
+	}
+}
+
+// AES, or its inverse cipher, with Alice's key and Bob's plaintext, both being in little endian as the TinyGarble AES
+// circuit expects them. The output is the ciphertext, in little endian too.
+func referenceAESBlock(alice string, bob string, decrypt bool) (string, error) {
+	key, err := decodeLittleEndian(alice)
+	if err != nil {
+		return "", err
+	}
+	plain, err := decodeLittleEndian(bob)
+	if err != nil {
+		return "", err
+	}
+	if len(plain) != aes.BlockSize {
+		return "", fmt.Errorf("%w: AES needs a 128 bits input, got %d bits", ErrInvalidHex, 8*len(plain))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	ct := make([]byte, aes.BlockSize)
+	if decrypt {
+		block.Decrypt(ct, plain)
+	} else {
+		block.Encrypt(ct, plain)
+	}
+	return SwapEndianness(strings.ToUpper(hex.EncodeToString(ct)))
+}
+
+// The Hamming distance between Alice and Bob's 32 bits inputs
+func referenceHamming(alice string, bob string) (string, error) {
+	// The strconv errors hold the inputs, which are secret, so we don't wrap them
+	a, err := strconv.ParseUint(alice, 16, 32)
+	if err != nil {
+		return "", fmt.Errorf("%w: Alice's input isn't a 32 bits hexadecimal value", ErrInvalidHex)
+	}
+	b, err := strconv.ParseUint(bob, 16, 32)
+	if err != nil {
+		return "", fmt.Errorf("%w: Bob's input isn't a 32 bits hexadecimal value", ErrInvalidHex)
+	}
+	return fmt.Sprintf("%02X", bits.OnesCount32(uint32(a^b))), nil
+}
+
+// Decodes an hexadecimal string written in little endian into big endian bytes
+func decodeLittleEndian(data string) ([]byte, error) {
+	be, err := SwapEndianness(data)
+	if err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(be)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHex, err)
+	}
+	return b, nil
+}
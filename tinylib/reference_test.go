@@ -0,0 +1,83 @@
+package tinylib
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The reference AES has to follow TinyGarble's little endian convention, as shown in checks/checks.go
+func TestReferenceAES(t *testing.T) {
+	// "Thats my Kung Fu" and "Two One Nine Two" in little endian, as in checks/checks.go
+	key := "754620676E754B20796D207374616854"
+	referenceAES := referenceAESSized(16, false)
+	out, err := referenceAES(key, "6F775420656E694E20656E4F206F7754")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The ciphertext for this key and plaintext, in little endian
+	if want := ReverseEndianness("29C3505F571420F6402299B31A02D73A"); out != want {
+		t.Error("Expected", want, "got", out)
+	}
+
+	if _, err = referenceAES(key, "00"); err == nil {
+		t.Error("Expected an error for a too short plaintext")
+	}
+}
+
+func TestReferenceHamming(t *testing.T) {
+	out, err := referenceHamming("FF55AA77", "12345678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "13" {
+		t.Error("Expected 13, got", out)
+	}
+}
+
+func TestReferenceUnknownCircuit(t *testing.T) {
+	_, err := ReferenceBackend{}.Evaluate(context.Background(), Circuit{Path: "sha3_24cc.scd"}, "00", "127.0.0.1", 1234)
+	if err == nil || !strings.Contains(err.Error(), "sha3_24cc.scd") {
+		t.Error("Expected an unknown circuit error, got", err)
+	}
+}
+
+// With LoopbackOnly, the garbler can't be reached from the other interfaces
+func TestReferenceLoopbackOnly(t *testing.T) {
+	var ip net.IP
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+			ip = n.IP
+			break
+		}
+	}
+	if ip == nil {
+		t.Skip("No interface besides the loopback")
+	}
+
+	port := reservePorts(t, 1)
+	ctx, cancel := context.WithCancel(withLoopbackOnly(context.Background()))
+	defer cancel()
+	ready := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- ReferenceBackend{}.GarbleReady(ctx, Circuit{Path: "aes_1cc.scd"}, "00", port, func() { close(ready) })
+	}()
+	select {
+	case <-ready:
+	case err := <-done:
+		t.Fatal(err)
+	}
+	if conn, err := net.Dial("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port))); err == nil {
+		conn.Close()
+		t.Error("The garbler could be reached on", ip)
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,75 @@
+package tinylib
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Secret is a string, such as Alice's AES key, which must never be printed. It can be given to fmt or log
+// without leaking, since it always formats as [REDACTED].
+type Secret string
+
+// The text used in place of secrets in logs and errors
+const redacted = "[REDACTED]"
+
+func (Secret) String() string {
+	return redacted
+}
+
+func (Secret) GoString() string {
+	return redacted
+}
+
+// Format makes sure that every verb, including %x and %q, prints the redacted text
+func (Secret) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, redacted)
+}
+
+// Replaces every occurrence of the secret in the text, in case some tool echoed it
+func redact(text string, secret string) string {
+	if secret == "" {
+		return text
+	}
+	return strings.ReplaceAll(text, secret, redacted)
+}
+
+// Writes the data into a new file only readable by us, in a new directory only accessible by us. The returned function
+// overwrites the file with zeros before removing it along with its directory, so that the data doesn't stay on disk.
+func writeSecretFile(data string) (string, func() error, error) {
+	dir, err := os.MkdirTemp("", "tinylib-")
+	if err != nil {
+		return "", nil, err
+	}
+	f, err := os.OpenFile(dir+"/input", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	path := f.Name()
+	remove := func() error {
+		defer os.RemoveAll(dir)
+		w, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(make([]byte, len(data)))
+		if err == nil {
+			err = w.Sync()
+		}
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	if _, err = f.WriteString(data); err != nil {
+		f.Close()
+		remove()
+		return "", nil, err
+	}
+	if err = f.Close(); err != nil {
+		remove()
+		return "", nil, err
+	}
+	return path, remove, nil
+}
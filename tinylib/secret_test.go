@@ -0,0 +1,53 @@
+package tinylib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretFormat(t *testing.T) {
+	key := Secret("2b7e151628aed2a6abf7158809cf4f3c")
+	for _, format := range []string{"%s", "%v", "%+v", "%#v", "%x", "%q", "%d"} {
+		if got := fmt.Sprintf(format, key); got != "[REDACTED]" {
+			t.Errorf("Expected [REDACTED] with %s, got %s", format, got)
+		}
+	}
+	if got := fmt.Sprintln("key:", key); got != "key: [REDACTED]\n" {
+		t.Error("Unexpected Sprintln output", got)
+	}
+}
+
+// The secret file must only be readable by us, and must be gone once removed
+func TestWriteSecretFile(t *testing.T) {
+	path, remove, err := writeSecretFile("2b7e151628aed2a6abf7158809cf4f3c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Error("Expected a 0600 file, got", info.Mode().Perm())
+	}
+	dirInfo, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirInfo.Mode().Perm() != 0700 {
+		t.Error("Expected a 0700 directory, got", dirInfo.Mode().Perm())
+	}
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != "2b7e151628aed2a6abf7158809cf4f3c" {
+		t.Error("Unexpected content", string(content), err)
+	}
+
+	if err = remove(); err != nil {
+		t.Error(err)
+	}
+	if _, err = os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+		t.Error("Expected the secret directory to be removed, got", err)
+	}
+}
@@ -0,0 +1,330 @@
+package tinylib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// InputMode tells which TinyGarble flag is used to give the input data to the circuit
+type InputMode int
+
+const (
+	// InputAuto uses --input for 1 clock cycle circuits and --init otherwise, which is what most of the TinyGarble circuits expect
+	InputAuto InputMode = iota
+	// InputInit always uses the --init flag
+	InputInit
+	// InputFlag always uses the --input flag, some circuits using more than 1 clock cycle need it
+	InputFlag
+)
+
+// CounterLayout tells how the counter blocks of the CTR mode are split between a fixed nonce and a counter incremented
+// for each block. Once the counter has taken all its values, the encryption fails with ErrCounterExhausted rather than
+// wrapping around and reusing the keystream.
+type CounterLayout int
+
+const (
+	// Counter64 uses a 64 bits nonce followed by a 64 bits counter, this is the default, and what AESCTR always used
+	Counter64 CounterLayout = iota
+	// Counter32 uses a 96 bits nonce followed by a 32 bits counter, as GCM and many AES-CTR implementations do
+	Counter32
+	// Counter128 increments the whole 128 bits block, as the standard library's cipher.NewCTR does
+	Counter128
+)
+
+func (l CounterLayout) String() string {
+	switch l {
+	case Counter64:
+		return "64 bits counter"
+	case Counter32:
+		return "32 bits counter"
+	case Counter128:
+		return "128 bits counter"
+	}
+	return fmt.Sprintf("CounterLayout(%d)", int(l))
+}
+
+// Padding tells how CBC deals with data which isn't a whole number of blocks
+type Padding int
+
+const (
+	// PadCTS uses ciphertext stealing, so that the ciphertext is as long as the plaintext, this is the default and what
+	// AESCBC always did. The data has to be at least one block long, CBC-CS isn't defined for less.
+	PadCTS Padding = iota
+	// PadPKCS7 pads the data with n bytes of value n, from 1 to 16, as in PKCS#7 and RFC 5652
+	PadPKCS7
+	// PadISO7816 pads the data with a 0x80 byte followed by 0's, as in ISO/IEC 7816-4 (or ISO/IEC 9797-1 method 2)
+	PadISO7816
+)
+
+func (p Padding) String() string {
+	switch p {
+	case PadCTS:
+		return "ciphertext stealing"
+	case PadPKCS7:
+		return "PKCS#7"
+	case PadISO7816:
+		return "ISO/IEC 7816-4"
+	}
+	return fmt.Sprintf("Padding(%d)", int(p))
+}
+
+// A Session holds everything needed to run a given circuit with a Backend, by default TinyGarble.
+// A Session is never modified once built, so it is safe for concurrent use and
+// one can run for example an AES session and a Hamming session in the same process.
+type Session struct {
+	backend Backend
+	circuit Circuit
+	timeout time.Duration
+	// How many times the client tries to reach the server, and how long it waits at most between two attempts
+	attempts int
+	maxDelay time.Duration
+	counter  CounterLayout
+	padding  Padding
+	// The AES key size in bytes, 0 to take it from the circuit name
+	keySize int
+}
+
+// The default retries of the client: with the delay starting at 10ms and doubling, it waits for about 1s at most in total
+const (
+	defaultAttempts = 7
+	firstDelay      = 10 * time.Millisecond
+	defaultMaxDelay = 500 * time.Millisecond
+)
+
+// An Option configures a Session in NewSession
+type Option func(*Session) error
+
+// WithTinyGarble uses a TinyGarbleBackend with the given TinyGarble root directory, which defaults to $TINYGARBLE
+func WithTinyGarble(path string) Option {
+	return func(s *Session) error {
+		if path == "" {
+			return errors.New("tinylib: empty TinyGarble path")
+		}
+		s.backend = TinyGarbleBackend{Path: path}
+		return nil
+	}
+}
+
+// WithBackend sets the engine used to run the circuit, instead of TinyGarble
+func WithBackend(b Backend) Option {
+	return func(s *Session) error {
+		if b == nil {
+			return errors.New("tinylib: nil backend")
+		}
+		s.backend = b
+		return nil
+	}
+}
+
+// WithCircuit sets the path to the .scd circuit file to use
+func WithCircuit(path string) Option {
+	return func(s *Session) error {
+		if path == "" {
+			return errors.New("tinylib: empty circuit path")
+		}
+		s.circuit.Path = path
+		return nil
+	}
+}
+
+// WithKeySize sets the key size in bytes of the session's AES circuit: 16, 24 or 32 for AES-128, AES-192 or AES-256.
+// It is needed when the circuit file isn't named as TinyGarble names them, see Session.KeySize.
+func WithKeySize(n int) Option {
+	return func(s *Session) error {
+		if n != 16 && n != 24 && n != 32 {
+			return fmt.Errorf("tinylib: invalid AES key size %d, it has to be 16, 24 or 32 bytes", n)
+		}
+		s.keySize = n
+		return nil
+	}
+}
+
+// WithClockCycles sets the number of clock cycles needed by the circuit, it defaults to 1
+func WithClockCycles(n int) Option {
+	return func(s *Session) error {
+		if n < 1 {
+			return fmt.Errorf("tinylib: invalid number of clock cycles %d", n)
+		}
+		s.circuit.ClockCycles = n
+		return nil
+	}
+}
+
+// WithInputMode sets which TinyGarble flag is used for the input data, it defaults to InputAuto
+func WithInputMode(mode InputMode) Option {
+	return func(s *Session) error {
+		if mode < InputAuto || mode > InputFlag {
+			return fmt.Errorf("tinylib: invalid input mode %d", mode)
+		}
+		s.circuit.Input = mode
+		return nil
+	}
+}
+
+// WithCounterLayout sets the layout of the counter blocks of the session's CTR mode, Counter64 by default.
+func WithCounterLayout(l CounterLayout) Option {
+	return func(s *Session) error {
+		if l < Counter64 || l > Counter128 {
+			return fmt.Errorf("tinylib: invalid counter layout %d", l)
+		}
+		s.counter = l
+		return nil
+	}
+}
+
+// WithPadding sets how the session's CBC mode deals with data which isn't a whole number of blocks, PadCTS by default.
+// With a padding, the ciphertext is up to one block longer than the plaintext, and any plaintext, even empty, works.
+func WithPadding(p Padding) Option {
+	return func(s *Session) error {
+		if p < PadCTS || p > PadISO7816 {
+			return fmt.Errorf("tinylib: invalid padding %d", p)
+		}
+		s.padding = p
+		return nil
+	}
+}
+
+// WithTimeout bounds the duration of every single TinyGarble run of the session, on top of the context deadline.
+// The default is no timeout, so a client whose server never shows up will wait until its context is done.
+func WithTimeout(d time.Duration) Option {
+	return func(s *Session) error {
+		if d < 0 {
+			return fmt.Errorf("tinylib: invalid timeout %v", d)
+		}
+		s.timeout = d
+		return nil
+	}
+}
+
+// WithRetry sets how many times the client tries to reach the server before failing, when the error is
+// ErrConnectFailed, and the maximum delay between two attempts, the delay starting at 10ms and doubling each time.
+// Use 1 attempt to disable the retries.
+func WithRetry(attempts int, maxDelay time.Duration) Option {
+	return func(s *Session) error {
+		if attempts < 1 || maxDelay < 0 {
+			return fmt.Errorf("tinylib: invalid retries %d, %v", attempts, maxDelay)
+		}
+		s.attempts = attempts
+		s.maxDelay = maxDelay
+		return nil
+	}
+}
+
+// NewSession builds a Session from the given options. A circuit is required, and so is a backend unless $TINYGARBLE is set.
+func NewSession(opts ...Option) (*Session, error) {
+	s := &Session{
+		circuit:  Circuit{ClockCycles: 1, Input: InputAuto},
+		attempts: defaultAttempts,
+		maxDelay: defaultMaxDelay,
+	}
+	if path := os.Getenv("TINYGARBLE"); path != "" {
+		s.backend = TinyGarbleBackend{Path: path}
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.backend == nil {
+		return nil, errors.New("tinylib: no backend, use WithTinyGarble, WithBackend or set $TINYGARBLE")
+	}
+	if s.circuit.Path == "" {
+		return nil, errors.New("tinylib: circuit not set, use WithCircuit")
+	}
+	return s, nil
+}
+
+// The client (Bob) side, it returns the raw output of the backend.
+// If TinyGarble fails, the error is a *TinyGarbleError holding its exit code. If the context is done
+// or the session timeout expires before TinyGarble is done, TinyGarble is killed and the context error is returned.
+// If the server can't be reached, it tries again after a while, see WithRetry.
+func (s *Session) Client(ctx context.Context, data string, addr string, port int) (string, error) {
+	fmt.Printf("\tClient running on address %s and port %d.\n", addr, port)
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	delay := firstDelay
+	for attempt := 1; ; attempt++ {
+		out, err := s.backend.Evaluate(ctx, s.circuit, data, addr, port)
+		if err == nil || attempt >= s.attempts || !errors.Is(err, ErrConnectFailed) {
+			return out, err
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, s.maxDelay)
+	}
+}
+
+// The server (Alice) side, for one evaluation of the circuit.
+// It returns ErrPortUnavailable if something is already listening on the port and a *TinyGarbleError if TinyGarble fails.
+// As for Client, TinyGarble is killed when the context is done. See StartServer to know when Bob can be started.
+func (s *Session) Server(ctx context.Context, data string, port int) error {
+	fmt.Printf("\tServer running on port %d.\n", port)
+	return s.server(ctx, data, port, nil)
+}
+
+// A peer tells Bob where to find Alice's garbler for each of the evaluations needed by a mode of operation
+type peer interface {
+	// Evaluates the circuit with Bob's data against the next garbler
+	evaluate(ctx context.Context, data string) (string, error)
+}
+
+// The fixed schedule of RunServer: Alice's garblers are on consecutive ports, starting at port
+type portSchedule struct {
+	session *Session
+	addr    string
+	port    int
+}
+
+func (p *portSchedule) evaluate(ctx context.Context, data string) (string, error) {
+	out, err := p.session.Client(ctx, data, p.addr, p.port)
+	p.port++
+	return out, err
+}
+
+// This allows to run a server a given number of time "rounds", incrementing the port number each time to avoid problems with the TIME_WAIT.
+// A negative number of rounds runs until the context is done, and it stops at the first round which fails.
+// See StartRunServer to know when Bob can be started.
+func (s *Session) RunServer(ctx context.Context, key string, startingPort int, rounds int) error {
+	return s.runServer(ctx, key, startingPort, rounds, nil)
+}
+
+// The RunServer loop, calling ready once the first garbler listens, if ready isn't nil
+func (s *Session) runServer(ctx context.Context, key string, startingPort int, rounds int, ready func()) error {
+	if rounds == 0 && ready != nil {
+		ready()
+	}
+	// Bob has to follow the same fixed schedule, see ControlServer for a server where Bob asks for the next port
+	// and tells when he is done. Here, we use a fixed number of rounds:
+	for rounds != 0 { // This allows unending server cycles
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fmt.Printf("\tServer running on port %d.\n", startingPort)
+		// Note that this will fail with ErrPortUnavailable if the next port isn't available, see PortManager.ReserveRange
+		if err := s.server(ctx, key, startingPort, ready); err != nil {
+			return err
+		}
+		// Only the first garbler is waited for
+		ready = nil
+		startingPort++
+		rounds--
+		// This terminates when rounds == 0
+	}
+	return nil
+}
+
+// Applies the session timeout, if any, to the context
+func (s *Session) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout > 0 {
+		return context.WithTimeout(ctx, s.timeout)
+	}
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,147 @@
+package tinylib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewSession(t *testing.T) {
+	_, err := NewSession(WithTinyGarble("/opt/TinyGarble"))
+	if err == nil {
+		t.Error("Expected an error when no circuit is given")
+	}
+
+	_, err = NewSession(WithTinyGarble("/opt/TinyGarble"), WithCircuit("aes_1cc.scd"), WithClockCycles(0))
+	if err == nil {
+		t.Error("Expected an error for 0 clock cycles")
+	}
+
+	s, err := NewSession(WithTinyGarble("/opt/TinyGarble"), WithCircuit("aes_1cc.scd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := s.backend.(TinyGarbleBackend).binary(); b != "/opt/TinyGarble/bin/garbled_circuit/TinyGarble" {
+		t.Error("Unexpected TinyGarble binary", b)
+	}
+
+	t.Setenv("TINYGARBLE", "")
+	_, err = NewSession(WithCircuit("aes_1cc.scd"))
+	if err == nil {
+		t.Error("Expected an error when no backend is given")
+	}
+}
+
+// Building the arguments of a 1cc session must not change how an 8cc session behaves, which was the case with the SetCircuit globals
+func TestSessionsIndependent(t *testing.T) {
+	aes, _ := NewSession(WithTinyGarble("/opt/TinyGarble"), WithCircuit("aes_1cc.scd"))
+	hamming, _ := NewSession(WithTinyGarble("/opt/TinyGarble"), WithCircuit("hamming_32bit_8cc.scd"), WithClockCycles(8))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			inputArgs(aes.circuit, "00", false)
+		}()
+		go func() {
+			defer wg.Done()
+			if args := inputArgs(hamming.circuit, "00", false); args[2] != "--init" {
+				t.Error("Expected --init for the 8cc session, got", args)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Creates a fake TinyGarble root whose binary is the given shell script
+func fakeTinyGarble(t *testing.T, script string) string {
+	return fakeTinyGarbleHelp(t, "  --input_file arg\n  --init_file arg", script)
+}
+
+// A fake TinyGarble printing the given help, e.g. the upstream one without --input_file
+func fakeTinyGarbleHelp(t *testing.T, help string, script string) string {
+	root := t.TempDir()
+	script = "if [ \"$1\" = --help ]; then printf '" + help + "\\n'; exit 0; fi\n" + script
+	dir := filepath.Join(root, "bin", "garbled_circuit")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "TinyGarble"), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// A client whose server never shows up must be killed once its context or the session timeout expires
+func TestClientTimeout(t *testing.T) {
+	root := fakeTinyGarble(t, "exec sleep 60")
+
+	s, err := NewSession(WithTinyGarble(root), WithCircuit("aes_1cc.scd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = s.Client(ctx, "00", "127.0.0.1", 1234)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected context.DeadlineExceeded, got", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("TinyGarble wasn't killed on time")
+	}
+
+	s, err = NewSession(WithTinyGarble(root), WithCircuit("aes_1cc.scd"), WithTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Client(context.Background(), "00", "127.0.0.1", 1234)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected context.DeadlineExceeded with the session timeout, got", err)
+	}
+}
+
+// An endless server has to stop when its context is cancelled
+func TestRunServerCancel(t *testing.T) {
+	root := fakeTinyGarble(t, "exec sleep 60")
+	s, err := NewSession(WithTinyGarble(root), WithCircuit("aes_1cc.scd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.RunServer(ctx, "00", 0, -1)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Error("Expected context.Canceled, got", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunServer didn't stop after its context was cancelled")
+	}
+}
+
+// A TinyGarble exit status is reported as a *TinyGarbleError
+func TestClientExitStatus(t *testing.T) {
+	root := fakeTinyGarble(t, "echo oops >&2; exit 255")
+	s, err := NewSession(WithTinyGarble(root), WithCircuit("aes_1cc.scd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Client(context.Background(), "00", "127.0.0.1", 1234)
+	var tgErr *TinyGarbleError
+	if !errors.As(err, &tgErr) || tgErr.ExitCode != 255 || tgErr.Stderr != "oops\n" {
+		t.Error("Expected a *TinyGarbleError with exit status 255, got", err)
+	}
+}
@@ -0,0 +1,120 @@
+package tinylib
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+)
+
+// SIVSeal encrypts the plaintext with AES-SIV (RFC 5297), which is deterministic: the same plaintext and additional
+// data always give the same ciphertext, so that encrypted records can be deduplicated, and reusing a nonce only tells
+// that the messages are the same. The synthetic IV is computed by S2V, with CMAC under the key of mac, and the
+// plaintext is then encrypted in CTR mode under the key of ctr: Alice holds both keys, which are the two halves of the
+// RFC's key, and every AES call is an evaluation against her garblers. For the nonce based use, give the nonce as the
+// last additional data. The output is the 16 bytes synthetic IV followed by the ciphertext.
+//
+// mac needs one garbler for the subkeys, one for the empty block, and one per block of each additional data and of
+// the plaintext, at least one each. ctr needs one per block of plaintext.
+func SIVSeal(mac, ctr *GarbledBlock, plaintext []byte, additionalData ...[]byte) ([]byte, error) {
+	fmt.Println("\tAES SIV sealing started")
+	v, err := s2v(mac, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := sivCTR(ctr, v, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return append(v[:], ciphertext...), nil
+}
+
+// SIVOpen decrypts a ciphertext of SIVSeal and checks its synthetic IV against the plaintext and the additional data,
+// returning ErrAuthFailed if they were tampered with. It uses as many garblers as SIVSeal.
+func SIVOpen(mac, ctr *GarbledBlock, ciphertext []byte, additionalData ...[]byte) ([]byte, error) {
+	fmt.Println("\tAES SIV opening started")
+	if len(ciphertext) < BlockSize {
+		return nil, fmt.Errorf("%w: a SIV ciphertext has at least its %d bytes synthetic IV", ErrDataTooShort, BlockSize)
+	}
+	v := [BlockSize]byte(ciphertext[:BlockSize])
+	plaintext, err := sivCTR(ctr, v, ciphertext[BlockSize:])
+	if err != nil {
+		return nil, err
+	}
+	expected, err := s2v(mac, plaintext, additionalData)
+	if err != nil {
+		clear(plaintext)
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(expected[:], v[:]) != 1 {
+		clear(plaintext)
+		return nil, ErrAuthFailed
+	}
+	return plaintext, nil
+}
+
+// AESSIVSeal works as SIVSeal, Alice running RunServer with the CMAC key from macPort, and with the CTR key from
+// ctrPort. The session's circuit is the AES encryption one.
+func (s *Session) AESSIVSeal(ctx context.Context, plaintext []byte, additionalData [][]byte, addr string, macPort, ctrPort int) ([]byte, error) {
+	return SIVSeal(s.Block(ctx, addr, macPort), s.Block(ctx, addr, ctrPort), plaintext, additionalData...)
+}
+
+// AESSIVOpen works as SIVOpen, with the ports of AESSIVSeal.
+func (s *Session) AESSIVOpen(ctx context.Context, ciphertext []byte, additionalData [][]byte, addr string, macPort, ctrPort int) ([]byte, error) {
+	return SIVOpen(s.Block(ctx, addr, macPort), s.Block(ctx, addr, ctrPort), ciphertext, additionalData...)
+}
+
+// S2V, the CMAC of the additional data and the plaintext, folded together by doubling
+func s2v(b *GarbledBlock, plaintext []byte, additionalData [][]byte) ([BlockSize]byte, error) {
+	var v [BlockSize]byte
+	k1, k2, err := cmacSubkeys(b)
+	if err != nil {
+		return v, err
+	}
+	defer clear(k1[:])
+	defer clear(k2[:])
+
+	d, err := cmacWith(b, &k1, &k2, make([]byte, BlockSize))
+	if err != nil {
+		return v, err
+	}
+	for _, data := range additionalData {
+		mac, err := cmacWith(b, &k1, &k2, data)
+		if err != nil {
+			return v, err
+		}
+		double(&d, &d)
+		subtle.XORBytes(d[:], d[:], mac[:])
+	}
+
+	// The plaintext is xored with D at its end if it's at least a block long, otherwise padded and xored with 2D
+	var t []byte
+	if len(plaintext) >= BlockSize {
+		t = make([]byte, len(plaintext))
+		copy(t, plaintext)
+		end := t[len(t)-BlockSize:]
+		subtle.XORBytes(end, end, d[:])
+	} else {
+		t = make([]byte, BlockSize)
+		n := copy(t, plaintext)
+		padBlock(t, n, PadISO7816)
+		double(&d, &d)
+		subtle.XORBytes(t, t, d[:])
+	}
+	defer clear(t)
+	return cmacWith(b, &k1, &k2, t)
+}
+
+// The CTR mode of SIV, whose initial counter is the synthetic IV with the 32nd and 64th bits from the end cleared,
+// incremented as a whole block whatever the counter layout of the block
+func sivCTR(b *GarbledBlock, v [BlockSize]byte, src []byte) ([]byte, error) {
+	v[8] &= 0x7f
+	v[12] &= 0x7f
+	s := newCTRStream(b, v)
+	s.layout = Counter128
+	defer s.clear()
+	dst := make([]byte, len(src))
+	if _, err := s.xorKeyStream(dst, src); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
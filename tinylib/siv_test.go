@@ -0,0 +1,140 @@
+package tinylib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// The examples of RFC 5297 appendix A, whose keys are split into the CMAC and the CTR ones
+var sivVectors = []struct {
+	name      string
+	mac, ctr  string
+	ad        []string
+	plaintext string
+	output    string
+}{
+	{
+		"A.1 deterministic",
+		"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0", "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff",
+		[]string{"101112131415161718191a1b1c1d1e1f2021222324252627"},
+		"112233445566778899aabbccddee",
+		"85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c",
+	},
+	{
+		"A.2 nonce based",
+		"7f7e7d7c7b7a79787776757473727170", "404142434445464748494a4b4c4d4e4f",
+		[]string{"00112233445566778899aabbccddeeffdeaddadadeaddadaffeeddccbbaa99887766554433221100", "102030405060708090a0", "09f911029d74e35bd84156c5635688c0"},
+		"7468697320697320736f6d6520706c61696e7465787420746f20656e6372797074207573696e67205349562d414553",
+		"7bdb6e3b432667eb06f4d14bff2fbd0fcb900f2fddbe404326601965c889bf17dba77ceb094fa663b7a3f748ba8af829ea64ad544a272e9c485b62a3fd5c0d",
+	},
+}
+
+func TestSIV(t *testing.T) {
+	for _, v := range sivVectors {
+		mac, _ := localBlocks(t, v.mac, PadCTS)
+		ctr, _ := localBlocks(t, v.ctr, PadCTS)
+		var ad [][]byte
+		for _, a := range v.ad {
+			ad = append(ad, mustHex(t, a))
+		}
+		pt, expected := mustHex(t, v.plaintext), mustHex(t, v.output)
+
+		sealed, err := SIVSeal(mac, ctr, pt, ad...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(sealed, expected) {
+			t.Errorf("%s: expected %x, got %x", v.name, expected, sealed)
+		}
+		opened, err := SIVOpen(mac, ctr, sealed, ad...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(opened, pt) {
+			t.Errorf("%s: expected %x, got %x", v.name, pt, opened)
+		}
+
+		// Tampering with the synthetic IV, the ciphertext or the additional data is detected
+		for _, i := range []int{0, BlockSize, len(sealed) - 1} {
+			tampered := bytes.Clone(sealed)
+			tampered[i] ^= 0x20
+			if _, err := SIVOpen(mac, ctr, tampered, ad...); !errors.Is(err, ErrAuthFailed) {
+				t.Errorf("%s, byte %d flipped: expected ErrAuthFailed, got %v", v.name, i, err)
+			}
+		}
+		if _, err := SIVOpen(mac, ctr, sealed, ad[:len(ad)-1]...); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("%s: expected ErrAuthFailed without the last additional data, got %v", v.name, err)
+		}
+	}
+
+	mac, ctr := localBlocks(t, sivVectors[0].mac, PadCTS)
+	if _, err := SIVOpen(mac, ctr, make([]byte, BlockSize-1)); !errors.Is(err, ErrDataTooShort) {
+		t.Error("Expected ErrDataTooShort, got", err)
+	}
+}
+
+// The deterministic example through the garblers, Alice serving each key on its own ports
+func TestAESSIV(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	v := sivVectors[0]
+	ad := [][]byte{mustHex(t, v.ad[0])}
+	// The subkeys, the empty block, two blocks of additional data and one of plaintext
+	macBlocks, ctrBlocks := 5, 1
+	sealed, err := s.AESSIVSeal(context.Background(), mustHex(t, v.plaintext), ad, "127.0.0.1",
+		startAES(t, s, v.mac, macBlocks), startAES(t, s, v.ctr, ctrBlocks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sealed, mustHex(t, v.output)) {
+		t.Errorf("Expected %s, got %x", v.output, sealed)
+	}
+	opened, err := s.AESSIVOpen(context.Background(), sealed, ad, "127.0.0.1",
+		startAES(t, s, v.mac, macBlocks), startAES(t, s, v.ctr, ctrBlocks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, mustHex(t, v.plaintext)) {
+		t.Errorf("Expected %s, got %x", v.plaintext, opened)
+	}
+}
+
+// Sealing concurrently with the same blocks takes the garblers one at a time, never the same port twice
+func TestSIVConcurrent(t *testing.T) {
+	macBackend := &stubBackend{output: "000102030405060708090A0B0C0D0E0F\n"}
+	ctrBackend := &stubBackend{output: "000102030405060708090A0B0C0D0E0F\n"}
+	macSession, err := NewSession(WithBackend(macBackend), WithCircuit("aes_1cc.scd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrSession, err := NewSession(WithBackend(ctrBackend), WithCircuit("aes_1cc.scd"), WithCounterLayout(Counter32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac, ctr := macSession.Block(context.Background(), "127.0.0.1", 4000), ctrSession.Block(context.Background(), "127.0.0.1", 5000)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := SIVSeal(mac, ctr, make([]byte, 3*BlockSize)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, b := range []*stubBackend{macBackend, ctrBackend} {
+		seen := make(map[int]bool)
+		for _, port := range b.ports {
+			if seen[port] {
+				t.Error("Port", port, "used twice")
+			}
+			seen[port] = true
+		}
+	}
+	if len(ctrBackend.ports) != 4*3 {
+		t.Error("Expected 12 CTR evaluations, got", len(ctrBackend.ports))
+	}
+}
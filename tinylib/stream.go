@@ -0,0 +1,396 @@
+package tinylib
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// The keystream of the CTR mode, generated one block at a time as the data comes, so that we only ask Alice for a
+// garbler once we need it
+type ctrStream struct {
+	b      *GarbledBlock
+	layout CounterLayout
+	// The initial counter block, and the current one
+	iv, counter [BlockSize]byte
+	// Set once the counter can't be incremented anymore, the next keystream block can't be generated then
+	exhausted bool
+
+	keystream [BlockSize]byte
+	// How much of the keystream block is used already
+	used int
+	// How much of the next keystream block has to be skipped, after a seek
+	skip int
+}
+
+func newCTRStream(b *GarbledBlock, iv [BlockSize]byte) *ctrStream {
+	s := &ctrStream{counter: iv, iv: iv, used: BlockSize}
+	if b != nil {
+		s.b, s.layout = b, b.counter
+	}
+	return s
+}
+
+// Moves the stream to the given byte offset, only the counter block of this offset will be encrypted
+func (s *ctrStream) seek(offset int64) error {
+	if offset < 0 {
+		return fmt.Errorf("tinylib: negative CTR offset %d", offset)
+	}
+	s.counter = s.iv
+	s.exhausted = false
+	s.clear()
+	if err := s.add(uint64(offset / BlockSize)); err != nil {
+		// The offset may be right after the last block of the counter, as long as we don't read from there
+		if offset%BlockSize != 0 || s.add(uint64(offset/BlockSize)-1) != nil {
+			return err
+		}
+		s.exhausted = true
+	}
+	s.skip = int(offset % BlockSize)
+	return nil
+}
+
+// Xors src with the keystream into dst, which may be src itself. On error, dst[:n] has been processed.
+func (s *ctrStream) xorKeyStream(dst, src []byte) (n int, err error) {
+	for n < len(src) {
+		if s.used == BlockSize {
+			if s.exhausted {
+				return n, fmt.Errorf("%w: %v after %x", ErrCounterExhausted, s.layout, s.counter)
+			}
+			if err := s.b.EncryptBlock(s.keystream[:], s.counter[:]); err != nil {
+				return n, err
+			}
+			if s.add(1) != nil {
+				s.exhausted = true
+			}
+			s.used, s.skip = s.skip, 0
+		}
+		k := subtle.XORBytes(dst[n:], src[n:], s.keystream[s.used:])
+		s.used += k
+		n += k
+	}
+	return n, nil
+}
+
+// Adds n to the counter part of the counter block, it returns ErrCounterExhausted, leaving the counter as it is, if the
+// counter would wrap around
+func (s *ctrStream) add(n uint64) error {
+	switch s.layout {
+	case Counter32:
+		c := uint64(binary.BigEndian.Uint32(s.counter[12:]))
+		if n > math.MaxUint32-c {
+			return ErrCounterExhausted
+		}
+		binary.BigEndian.PutUint32(s.counter[12:], uint32(c+n))
+	case Counter128:
+		high, low := binary.BigEndian.Uint64(s.counter[:8]), binary.BigEndian.Uint64(s.counter[8:])
+		sum, carry := bits.Add64(low, n, 0)
+		high, overflow := bits.Add64(high, 0, carry)
+		if overflow != 0 {
+			return ErrCounterExhausted
+		}
+		binary.BigEndian.PutUint64(s.counter[:8], high)
+		binary.BigEndian.PutUint64(s.counter[8:], sum)
+	default:
+		c := binary.BigEndian.Uint64(s.counter[8:])
+		if n > math.MaxUint64-c {
+			return ErrCounterExhausted
+		}
+		binary.BigEndian.PutUint64(s.counter[8:], c+n)
+	}
+	return nil
+}
+
+// Wipes the keystream we still hold
+func (s *ctrStream) clear() {
+	clear(s.keystream[:])
+	s.used = BlockSize
+}
+
+// The cipher.Stream returned by GarbledBlock.NewCTR
+type stdCTR struct {
+	s *ctrStream
+}
+
+func (c stdCTR) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("tinylib: output smaller than input")
+	}
+	if _, err := c.s.xorKeyStream(dst, src); err != nil {
+		panic(err)
+	}
+}
+
+// NewCTR is used by cipher.NewCTR, so that the counter blocks are only encrypted when the keystream is needed, rather
+// than 32 blocks ahead, and each of them takes one of Alice's garblers. As in the standard library, the whole counter
+// block is incremented, whatever the CounterLayout of the session. The stream panics if an evaluation fails, see
+// NewCTRReader and NewCTRWriter for streams returning errors.
+func (b *GarbledBlock) NewCTR(iv []byte) cipher.Stream {
+	if len(iv) != BlockSize {
+		panic("tinylib: IV length must equal block size")
+	}
+	s := newCTRStream(b, [BlockSize]byte(iv))
+	s.layout = Counter128
+	return stdCTR{s}
+}
+
+// CTRReader decrypts, or encrypts, in CTR mode the data read from an underlying reader. The counter blocks are
+// encrypted as the data comes: reading n bytes uses about n/16 of Alice's garblers.
+type CTRReader struct {
+	r   io.Reader
+	s   *ctrStream
+	err error
+}
+
+// NewCTRReader returns a reader xoring the data of r with the CTR keystream of the garbled block, starting with the
+// counter block iv incremented as the session's CounterLayout says, as for EncryptCTR.
+func NewCTRReader(r io.Reader, b *GarbledBlock, iv [BlockSize]byte) *CTRReader {
+	return &CTRReader{r: r, s: newCTRStream(b, iv)}
+}
+
+// Read reads from the underlying reader and xors the data with the keystream. An error of the garbled evaluation is
+// returned along with the bytes processed before it, and the reader is then broken and keeps returning it.
+func (c *CTRReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.r.Read(p)
+	if n > 0 {
+		m, xerr := c.s.xorKeyStream(p[:n], p[:n])
+		if xerr != nil {
+			c.err = xerr
+			return m, xerr
+		}
+	}
+	return n, err
+}
+
+// Close wipes the keystream and closes the underlying reader if it is an io.Closer. An error of the underlying reader,
+// such as io.EOF on a file which is still growing, leaves the keystream as it is, so that Read can be called again.
+func (c *CTRReader) Close() error {
+	c.s.clear()
+	if c.err == nil {
+		c.err = io.ErrClosedPipe
+	}
+	if closer, ok := c.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Seek sets the offset of the next Read, if the underlying reader is an io.Seeker, and moves the keystream accordingly
+// without encrypting the counter blocks skipped.
+func (c *CTRReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := c.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("tinylib: the underlying reader can't seek")
+	}
+	pos, err := seeker.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	if err := c.s.seek(pos); err != nil {
+		return pos, err
+	}
+	c.err = nil
+	return pos, nil
+}
+
+// CTRReaderAt gives random access to the CTR encrypted data of an io.ReaderAt: reading a range only encrypts the
+// counter blocks of this range, so that slices of large encrypted blobs can be decrypted without paying for the whole.
+// It is safe for concurrent use, as far as the underlying reader is.
+type CTRReaderAt struct {
+	r  io.ReaderAt
+	b  *GarbledBlock
+	iv [BlockSize]byte
+}
+
+// NewCTRReaderAt returns a CTRReaderAt decrypting the data of r, whose counter block at offset 0 is iv.
+func NewCTRReaderAt(r io.ReaderAt, b *GarbledBlock, iv [BlockSize]byte) *CTRReaderAt {
+	return &CTRReaderAt{r: r, b: b, iv: iv}
+}
+
+// ReadAt reads len(p) bytes from the underlying reader at offset off, and xors them with the keystream at this offset.
+func (c *CTRReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	if n > 0 {
+		s := newCTRStream(c.b, c.iv)
+		defer s.clear()
+		if serr := s.seek(off); serr != nil {
+			return 0, serr
+		}
+		if m, xerr := s.xorKeyStream(p[:n], p[:n]); xerr != nil {
+			return m, xerr
+		}
+	}
+	return n, err
+}
+
+// CTRWriter encrypts, or decrypts, in CTR mode the data written to it and writes it to an underlying writer.
+type CTRWriter struct {
+	w   io.Writer
+	s   *ctrStream
+	buf []byte
+	err error
+}
+
+// NewCTRWriter returns a writer xoring the data with the CTR keystream of the garbled block before writing it to w,
+// starting with the counter block iv incremented as the session's CounterLayout says, as for EncryptCTR.
+func NewCTRWriter(w io.Writer, b *GarbledBlock, iv [BlockSize]byte) *CTRWriter {
+	return &CTRWriter{w: w, s: newCTRStream(b, iv)}
+}
+
+// Write encrypts p and writes it to the underlying writer. Once an error occurred, the writer is broken and keeps
+// returning it, since the keystream wouldn't match the data anymore.
+func (c *CTRWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if cap(c.buf) < len(p) {
+		c.buf = make([]byte, len(p))
+	}
+	buf := c.buf[:len(p)]
+	n, err := c.s.xorKeyStream(buf, p)
+	if _, werr := c.w.Write(buf[:n]); werr != nil && err == nil {
+		err = werr
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// Close wipes the keystream and closes the underlying writer if it is an io.Closer.
+func (c *CTRWriter) Close() error {
+	c.s.clear()
+	clear(c.buf)
+	if c.err == nil {
+		c.err = io.ErrClosedPipe
+	}
+	if closer, ok := c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// CBCWriter encrypts in CBC mode the data written to it, as EncryptCBC does, with ciphertext stealing or the padding
+// of the session, and writes the ciphertext to an underlying writer. The blocks are encrypted as the data comes, but
+// the last ciphertext block is only written by Close, since it is swapped with the next one if the data ends with a
+// partial block, and the padding is only added there.
+type CBCWriter struct {
+	w io.Writer
+	b *GarbledBlock
+	// The padding of the block's session, or ciphertext stealing
+	padding Padding
+	// The previous ciphertext block, starting with the IV, which isn't written yet
+	prev [BlockSize]byte
+	// Whether we encrypted a block already, prev holding the IV otherwise
+	started bool
+	// The plaintext of the block in progress
+	pending [BlockSize]byte
+	n       int
+	err     error
+}
+
+// NewCBCWriter returns a writer encrypting the data in CBC mode with the garbled block before writing it to w.
+func NewCBCWriter(w io.Writer, b *GarbledBlock, iv [BlockSize]byte) *CBCWriter {
+	return &CBCWriter{w: w, b: b, padding: b.padding, prev: iv}
+}
+
+// Write encrypts the full blocks of data as they come. Once an error occurred, the writer is broken and keeps returning it.
+func (c *CBCWriter) Write(p []byte) (int, error) {
+	written := 0
+	for c.err == nil && len(p) > 0 {
+		if c.n == BlockSize {
+			c.err = c.encryptPending()
+			continue
+		}
+		k := copy(c.pending[c.n:], p)
+		c.n += k
+		written += k
+		p = p[k:]
+	}
+	return written, c.err
+}
+
+// Encrypts the full pending block, writing out the previous ciphertext block which can't be stolen from anymore
+func (c *CBCWriter) encryptPending() error {
+	if c.started {
+		if _, err := c.w.Write(c.prev[:]); err != nil {
+			return err
+		}
+	}
+	subtle.XORBytes(c.pending[:], c.pending[:], c.prev[:])
+	if err := c.b.EncryptBlock(c.prev[:], c.pending[:]); err != nil {
+		return err
+	}
+	clear(c.pending[:])
+	c.n = 0
+	c.started = true
+	return nil
+}
+
+// Close encrypts the last block, writes the end of the ciphertext and closes the underlying writer if it is an
+// io.Closer. As for EncryptCBC, it returns ErrDataTooShort if less than one block was written with ciphertext stealing.
+func (c *CBCWriter) Close() error {
+	err := c.finish()
+	clear(c.pending[:])
+	if c.err == nil {
+		c.err = io.ErrClosedPipe
+	}
+	if closer, ok := c.w.(io.Closer); ok {
+		err = errors.Join(err, closer.Close())
+	}
+	return err
+}
+
+func (c *CBCWriter) finish() error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.padding != PadCTS {
+		// There is always some padding, in a block of its own if the data is a whole number of blocks
+		if c.n == BlockSize {
+			if err := c.encryptPending(); err != nil {
+				return err
+			}
+		}
+		padBlock(c.pending[:], c.n, c.padding)
+		c.n = BlockSize
+		if err := c.encryptPending(); err != nil {
+			return err
+		}
+		_, err := c.w.Write(c.prev[:])
+		return err
+	}
+	if !c.started && c.n < BlockSize {
+		return fmt.Errorf("%w: CBC with ciphertext stealing needs at least 128 bits of data, use a padding for less", ErrDataTooShort)
+	}
+	if c.n == BlockSize || c.n == 0 {
+		if c.n == BlockSize {
+			if err := c.encryptPending(); err != nil {
+				return err
+			}
+		}
+		_, err := c.w.Write(c.prev[:])
+		return err
+	}
+
+	// ciphertext stealing in action: the last block is padded with 0's, and goes before the beginning of the previous one
+	stolen := c.prev
+	clear(c.pending[c.n:])
+	subtle.XORBytes(c.pending[:], c.pending[:], c.prev[:])
+	if err := c.b.EncryptBlock(c.prev[:], c.pending[:]); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(c.prev[:]); err != nil {
+		return err
+	}
+	_, err := c.w.Write(stolen[:c.n])
+	return err
+}
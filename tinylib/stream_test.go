@@ -0,0 +1,316 @@
+package tinylib
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// A GarbledBlock asking a ControlServer for as many garblers as needed, with the given AES key
+func controlBlock(t *testing.T, key string) *GarbledBlock {
+	s := testSession(t, "aes_1cc.scd")
+	ctx := context.Background()
+	c, err := s.Dial(ctx, startControlServer(t, s, key), "aes_1cc.scd", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c.Block(ctx)
+}
+
+// The SP 800-38A F.5.1 vectors, written in chunks which don't match the blocks
+func TestCTRWriter(t *testing.T) {
+	b := controlBlock(t, "2b7e151628aed2a6abf7158809cf4f3c")
+	iv := [BlockSize]byte(mustHex(t, "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff"))
+	pt := mustHex(t, "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	expected := mustHex(t, "874d6191b620e3261bef6864990db6ce9806f66b7970fdff8617187bb9fffdff5ae4df3edbd5d35e5b4f09020db03eab1e031dda2fbe03d1792170a0f3009cee")
+
+	var ct bytes.Buffer
+	w := NewCTRWriter(&ct, b, iv)
+	for _, chunk := range [][]byte{pt[:5], pt[5:16], pt[16:17], pt[17:50], pt[50:]} {
+		if n, err := w.Write(chunk); err != nil || n != len(chunk) {
+			t.Fatal("Write returned", n, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ct.Bytes(), expected) {
+		t.Errorf("Expected %x, got %x", expected, ct.Bytes())
+	}
+	if _, err := w.Write(pt); err == nil {
+		t.Error("Expected an error writing after Close")
+	}
+
+	// And back, one byte at a time
+	r := NewCTRReader(iotest.OneByteReader(bytes.NewReader(expected)), b, iv)
+	decrypted, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, pt) {
+		t.Errorf("Expected %x, got %x", pt, decrypted)
+	}
+}
+
+// A reader failing once after its first bytes, as a growing file gives io.EOF before more data is appended
+type flakyReader struct {
+	r      io.Reader
+	failed bool
+}
+
+func (f *flakyReader) Read(p []byte) (int, error) {
+	if !f.failed {
+		f.failed = true
+		n, _ := f.r.Read(p[:5])
+		return n, io.EOF
+	}
+	return f.r.Read(p)
+}
+
+// Reading again after an error of the underlying reader carries on in the middle of the keystream block
+func TestCTRReaderAfterError(t *testing.T) {
+	p := newCountingPeer(t, "2b7e151628aed2a6abf7158809cf4f3c")
+	b := &GarbledBlock{ctx: context.Background(), p: p}
+	iv := [BlockSize]byte(mustHex(t, "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff"))
+	plaintext := []byte("xxxxx and the rest of the data, over a few blocks")
+	ciphertext, err := CTREncrypt(b, plaintext, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewCTRReader(&flakyReader{r: bytes.NewReader(ciphertext)}, b, iv)
+	buf := make([]byte, len(plaintext))
+	n, err := r.Read(buf)
+	if n != 5 || err != io.EOF {
+		t.Fatal("Expected 5 bytes and io.EOF, got", n, err)
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted := append(buf[:n], rest...); !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Expected %q, got %q", plaintext, decrypted)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(buf); err == nil {
+		t.Error("Expected an error reading after Close")
+	}
+}
+
+// The RFC 3962 vectors, as for TestEncryptCBC, written in chunks
+func TestCBCWriter(t *testing.T) {
+	b := controlBlock(t, "636869636b656e207465726979616b69")
+	pt := mustHex(t, "4920776f756c64206c696b65207468652047656e6572616c20476175277320436869636b656e2c20706c656173652c20")
+	for _, v := range []struct {
+		n      int
+		cipher string
+	}{
+		{16, "97687268d6ecccc0c07b25e25ecfe584"},
+		{17, "c6353568f2bf8cb4d8a580362da7ff7f97"},
+		{31, "fc00783e0efdb2c1d445d4c8eff7ed2297687268d6ecccc0c07b25e25ecfe5"},
+		{32, "97687268d6ecccc0c07b25e25ecfe58439312523a78662d5be7fcbcc98ebf5a8"},
+		{47, "97687268d6ecccc0c07b25e25ecfe584b3fffd940c16a18c1b5549d2f838029e39312523a78662d5be7fcbcc98ebf5"},
+		{48, "97687268d6ecccc0c07b25e25ecfe58439312523a78662d5be7fcbcc98ebf5a89dad8bbb96c4cdc03bc103e1a194bbd8"},
+	} {
+		var ct bytes.Buffer
+		w := NewCBCWriter(&ct, b, [BlockSize]byte{})
+		for i := 0; i < v.n; i += 7 {
+			if _, err := w.Write(pt[i:min(i+7, v.n)]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ct.Bytes(), mustHex(t, v.cipher)) {
+			t.Errorf("%d bytes: expected %s, got %x", v.n, v.cipher, ct.Bytes())
+		}
+	}
+
+	w := NewCBCWriter(io.Discard, b, [BlockSize]byte{})
+	w.Write(pt[:15])
+	if err := w.Close(); !errors.Is(err, ErrDataTooShort) {
+		t.Error("Expected ErrDataTooShort, got", err)
+	}
+}
+
+// Once an evaluation failed, the streams keep returning its error
+func TestStreamErrors(t *testing.T) {
+	s, err := NewSession(WithBackend(ReferenceBackend{}), WithCircuit("aes_1cc.scd"), WithRetry(1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := s.Block(context.Background(), "127.0.0.1", reservePorts(t, 1))
+
+	w := NewCTRWriter(io.Discard, b, [BlockSize]byte{})
+	for i := 0; i < 2; i++ {
+		if _, err := w.Write([]byte("data")); !errors.Is(err, ErrConnectFailed) {
+			t.Error("Expected ErrConnectFailed, got", err)
+		}
+	}
+	r := NewCTRReader(bytes.NewReader([]byte("data")), b, [BlockSize]byte{})
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrConnectFailed) {
+		t.Error("Expected ErrConnectFailed, got", err)
+	}
+	cw := NewCBCWriter(io.Discard, b, [BlockSize]byte{})
+	if _, err := cw.Write(make([]byte, 40)); !errors.Is(err, ErrConnectFailed) {
+		t.Error("Expected ErrConnectFailed, got", err)
+	}
+	if err := cw.Close(); !errors.Is(err, ErrConnectFailed) {
+		t.Error("Expected ErrConnectFailed, got", err)
+	}
+}
+
+// A peer encrypting with a local AES key, counting the evaluations
+type countingPeer struct {
+	key   string
+	calls int
+}
+
+func (c *countingPeer) evaluate(ctx context.Context, data string) (string, error) {
+	c.calls++
+	return referenceAESSized(len(c.key)/2, false)(c.key, data)
+}
+
+func newCountingPeer(t *testing.T, key string) *countingPeer {
+	input, err := aesKeyInput(mustHex(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &countingPeer{key: input}
+}
+
+// Reading a range only encrypts the counter blocks of this range
+func TestCTRReaderAt(t *testing.T) {
+	p := newCountingPeer(t, "2b7e151628aed2a6abf7158809cf4f3c")
+	b := &GarbledBlock{ctx: context.Background(), p: p}
+	iv := [BlockSize]byte(mustHex(t, "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff"))
+	plaintext := bytes.Repeat([]byte("0123456789abcdefghijklmnopqrstuvwxyz"), 10)
+	ciphertext, err := CTREncrypt(b, plaintext, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewCTRReaderAt(bytes.NewReader(ciphertext), b, iv)
+	for _, v := range []struct {
+		off, n int
+		blocks int
+	}{{0, 16, 1}, {37, 20, 2}, {48, 1, 1}, {350, 20, 2}} {
+		p.calls = 0
+		buf := make([]byte, v.n)
+		n, err := r.ReadAt(buf, int64(v.off))
+		if v.off+v.n > len(ciphertext) {
+			if err != io.EOF || n != len(ciphertext)-v.off {
+				t.Error("Expected io.EOF after", len(ciphertext)-v.off, "bytes, got", n, err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], plaintext[v.off:v.off+n]) {
+			t.Errorf("At %d: expected %q, got %q", v.off, plaintext[v.off:v.off+n], buf[:n])
+		}
+		if p.calls != v.blocks {
+			t.Errorf("At %d: expected %d evaluations, got %d", v.off, v.blocks, p.calls)
+		}
+	}
+
+	// The same with a seeking reader
+	cr := NewCTRReader(bytes.NewReader(ciphertext), b, iv)
+	p.calls = 0
+	if _, err := cr.Seek(100, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(cr, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, plaintext[100:110]) || p.calls != 1 {
+		t.Errorf("Expected %q with 1 evaluation, got %q with %d", plaintext[100:110], buf, p.calls)
+	}
+	if _, err := NewCTRReader(iotest.OneByteReader(bytes.NewReader(ciphertext)), b, iv).Seek(1, io.SeekStart); err == nil {
+		t.Error("Expected an error seeking a reader which can't")
+	}
+}
+
+// Each layout only increments its counter, and fails rather than wrapping around. Counter128 gives the keystream of
+// the standard library across the 64 bits boundary.
+func TestCounterLayouts(t *testing.T) {
+	key := "2b7e151628aed2a6abf7158809cf4f3c"
+	p := newCountingPeer(t, key)
+	aesBlock, err := aes.NewCipher(mustHex(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := make([]byte, 3*BlockSize)
+	for _, v := range []struct {
+		layout CounterLayout
+		iv     string
+		// How many blocks can be encrypted before the counter is exhausted
+		blocks int
+	}{
+		{Counter64, "0000000000000000fffffffffffffffe", 2},
+		{Counter64, "ffffffffffffffff00000000fffffffe", 3},
+		{Counter32, "0000000000000000fffffffffffffffe", 2},
+		{Counter32, "000000000000000000000000fffffffe", 2},
+		{Counter128, "0000000000000000fffffffffffffffe", 3},
+		{Counter128, "fffffffffffffffffffffffffffffffe", 2},
+	} {
+		iv := [BlockSize]byte(mustHex(t, v.iv))
+		b := &GarbledBlock{ctx: context.Background(), p: p, counter: v.layout}
+		ct, err := CTREncrypt(b, plaintext, iv)
+		if v.blocks < 3 {
+			if !errors.Is(err, ErrCounterExhausted) {
+				t.Errorf("%v from %s: expected ErrCounterExhausted, got %v", v.layout, v.iv, err)
+			}
+			// The blocks before are fine
+			if _, err := CTREncrypt(b, plaintext[:v.blocks*BlockSize], iv); err != nil {
+				t.Errorf("%v from %s: %v", v.layout, v.iv, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v from %s: %v", v.layout, v.iv, err)
+		}
+		expected := make([]byte, len(plaintext))
+		cipher.NewCTR(aesBlock, iv[:]).XORKeyStream(expected, plaintext)
+		if v.layout != Counter64 && !bytes.Equal(ct, expected) {
+			t.Errorf("%v from %s: expected %x, got %x", v.layout, v.iv, expected, ct)
+		}
+	}
+
+	// Seeking right after the last block is fine, as long as we don't read
+	s := newCTRStream(&GarbledBlock{ctx: context.Background(), p: p, counter: Counter32}, [BlockSize]byte{})
+	if err := s.seek(BlockSize << 32); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	if _, err := s.xorKeyStream(make([]byte, 1), []byte{0}); !errors.Is(err, ErrCounterExhausted) {
+		t.Error("Expected ErrCounterExhausted, got", err)
+	}
+	if err := s.seek(BlockSize<<32 + 1); !errors.Is(err, ErrCounterExhausted) {
+		t.Error("Expected ErrCounterExhausted, got", err)
+	}
+	if err := s.seek(-1); err == nil {
+		t.Error("Expected an error for a negative offset")
+	}
+
+	// The session's layout is used by its blocks
+	session, err := NewSession(WithBackend(ReferenceBackend{}), WithCircuit("aes_1cc.scd"), WithCounterLayout(Counter32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := session.Block(context.Background(), "127.0.0.1", 1).counter; l != Counter32 {
+		t.Error("Expected the session's layout, got", l)
+	}
+	if _, err := NewSession(WithBackend(ReferenceBackend{}), WithCircuit("aes_1cc.scd"), WithCounterLayout(3)); err == nil {
+		t.Error("Expected an error for an invalid layout")
+	}
+}
@@ -1,295 +1,358 @@
 package tinylib
 
 import (
-	"bytes"
-	"crypto/rand"
-	"encoding/binary"
+	"context"
+	"crypto/subtle"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 )
 
-var tinyPath string
-var circuitPath string
-var clockCycles int
-var forceInput bool
+// The session used by the package level functions, as set by SetCircuit
+var defaultSession = &Session{backend: TinyGarbleBackend{}, circuit: Circuit{ClockCycles: 1},
+	attempts: defaultAttempts, maxDelay: defaultMaxDelay}
+var defaultMu sync.RWMutex
 
-// Be careful, you have to first set the TinyGarble Path and the Circuit Path to the AES-128 circuit, in order to use this
+// Whether the package level functions give the inputs on TinyGarble's command line, as set by SetArgvInput
+var defaultArgvInput bool
+
+// Returns the session set by the last call to SetCircuit
+func currentSession() *Session {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultSession
+}
+
+// Be careful, you have to first set the TinyGarble Path and the Circuit Path to the AES circuit, in order to use this
 // This function allows to use TinyGarble to encrypt more than 128 bits of data using CBC mode with ciphertext stealing (to avoid padding)
+//
+// Deprecated: AESCBC exits the program on any failure, use AESCBCErr instead.
 func AESCBC(data string, addr string, port int, o_iv ...string) ([]string, string) {
-	fmt.Println("\tAES CBC started")
-
-	var toCrypt []string
-	var cipher []string
-	// splitting the data into 128 bits blocks or less for the last one.
-	toCrypt = SplitData(data, 32)
-	// We are using ciphertext stealing to avoid to have to use padding on the data
-
-	//IV
-	iv := ""
-	//check wheter an optional iv is specified, since we just work with AES 128, we conditionnally check if it has the good length to be an iv
-	if len(o_iv) > 0 && len(o_iv[0]) == 32 {
-		fmt.Println("Using optionnal IV")
-		iv = o_iv[0]
-		//Note that we should also test wheter it is an hexadecimanl string for completness, but we don't as of now.
+	cipher, iv, err := AESCBCErr(data, addr, port, o_iv...)
+	if err != nil {
+		log.Fatal(err)
 	}
-	ivUsed := ivGeneration(iv)
-	// We can use the IV to do ciphertext stealing in case of <128 bits data, but this will be implemented later
-	if len(toCrypt[0]) < 32 {
-		log.Fatal("As of now, this CBC implementation needs at least 128 bits of data to encrypt them")
+	return cipher, iv
+}
+
+// The error returning version of AESCBC, using the circuit set by SetCircuit. It keeps the hexadecimal API of AESCBC,
+// unlike Session.EncryptCBC which works with bytes.
+func AESCBCErr(data string, addr string, port int, o_iv ...string) ([]string, string, error) {
+	return currentSession().AESCBC(context.Background(), data, addr, port, o_iv...)
+}
+
+// This allows to use TinyGarble to encrypt data using CBC mode with ciphertext stealing (to avoid padding), or with the
+// padding set by WithPadding, the session's circuit has to be the AES one. The data has to be an hexadecimal string,
+// of at least 128 bits with ciphertext stealing, otherwise ErrInvalidHex or ErrDataTooShort is returned.
+func (s *Session) AESCBC(ctx context.Context, data string, addr string, port int, o_iv ...string) ([]string, string, error) {
+	return aesCBC(s.Block(ctx, addr, port), data, o_iv...)
+}
+
+// The hexadecimal layer of the CBC mode, see CBCEncrypt
+func aesCBC(b *GarbledBlock, data string, o_iv ...string) ([]string, string, error) {
+	plaintext, iv, err := hexModeInput(data, o_iv...)
+	if err != nil {
+		return nil, "", err
 	}
-	// we set the IV as the first item used for xoring:
-	xoring := hex.EncodeToString(ivUsed)
-	// secure encryption of the data :
-	for i, r := range toCrypt {
-		dataLen := len(r)
-		// if we use ciphertext stealing we need to pad the data with 0's
-		if i == len(toCrypt)-1 && dataLen < 32 {
-			r += strings.Repeat("0", 32-dataLen)
-		}
-		// We have to reverse endianness since TinyGrable AES uses little endian
-		plain := ReverseEndianness(xorStr(r, xoring))
-
-		// We have to reverse endianness from the output to work again in big endian
-		ct := ReverseEndianness(YaoClient(plain, addr, port+i))
-		cipher = append(cipher, ct)
-		// ciphertext stealing in action:
-		if i == len(toCrypt)-1 && dataLen < 32 {
-			stolenCiph := xoring[:dataLen]
-			cipher = append(cipher[:len(cipher)-2], ct, stolenCiph)
-		}
-		xoring = ct
+	cipher, err := CBCEncrypt(b, plaintext, iv)
+	if err != nil {
+		return nil, "", err
 	}
+	return hexBlocks(cipher), hex.EncodeToString(iv[:]), nil
+}
 
-	return cipher, hex.EncodeToString(ivUsed)
+// This decrypts the output of AESCBC, given as one hexadecimal string, e.g. the joined blocks, with the IV it returned.
+// The session's circuit has to be the AES decryption one, see DecryptCBC. The plaintext is returned in blocks of
+// 128 bits, or less for the last one, as AESCBC returns the ciphertext.
+func (s *Session) AESCBCDecrypt(ctx context.Context, data string, addr string, port int, iv string) ([]string, error) {
+	return aesCBCDecrypt(s.Block(ctx, addr, port), data, iv)
+}
+
+// The hexadecimal layer of the CBC decryption, see CBCDecrypt
+func aesCBCDecrypt(b *GarbledBlock, data string, iv string) ([]string, error) {
+	ciphertext, ivBytes, err := hexDecryptInput(data, iv)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := CBCDecrypt(b, ciphertext, ivBytes)
+	if err != nil {
+		return nil, err
+	}
+	return hexBlocks(plain), nil
 }
 
-// Be careful, you have to first set the TinyGarble Path and the Circuit Path to the AES-128 circuit, in order to use this
+// Be careful, you have to first set the TinyGarble Path and the Circuit Path to the AES circuit, in order to use this
 // This function allows to use Tinygarble to encrypt more than 128 bit in a secure way through the use of CTR mode
+//
+// Deprecated: AESCTR exits the program on any failure, use AESCTRErr instead.
 func AESCTR(data string, addr string, port int, o_iv ...string) ([]string, string) {
-	fmt.Println("\tAES CTR started")
+	cipher, iv, err := AESCTRErr(data, addr, port, o_iv...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cipher, iv
+}
 
-	//lets splice our data into 32 char :
-	var toCrypt []string
-	var cipher []string
-	// we split the data into 128 bits or less for the last one. No padding needed for CTR mode
-	toCrypt = SplitData(data, 32)
+// The error returning version of AESCTR, using the circuit set by SetCircuit. It keeps the hexadecimal API of AESCTR,
+// unlike Session.EncryptCTR which works with bytes.
+func AESCTRErr(data string, addr string, port int, o_iv ...string) ([]string, string, error) {
+	return currentSession().AESCTR(context.Background(), data, addr, port, o_iv...)
+}
 
-	// Counter generation:
-	iv := ""
-	//check wheter an optional iv is specified, since we just work with AES 128, we conditionnally check if it has the good length to be an iv
-	if len(o_iv) > 0 && len(o_iv[0]) == 32 {
-		iv = o_iv[0]
-		//Note that we should also test wheter it is an hexadecimanl string for completness, but we don't as of now.
+// This allows to use Tinygarble to encrypt more than 128 bit in a secure way through the use of CTR mode, the session's
+// circuit has to be the AES one. The data may be an hexadecimal string of any length, ErrInvalidHex is returned
+// if it isn't valid hexadecimal.
+func (s *Session) AESCTR(ctx context.Context, data string, addr string, port int, o_iv ...string) ([]string, string, error) {
+	return aesCTR(s.Block(ctx, addr, port), data, o_iv...)
+}
+
+// The hexadecimal layer of the CTR mode, see CTREncrypt
+func aesCTR(b *GarbledBlock, data string, o_iv ...string) ([]string, string, error) {
+	plaintext, counter, err := hexModeInput(data, o_iv...)
+	if err != nil {
+		return nil, "", err
 	}
-	// counter size is 128 bits and is a random nonce unless a custom one is used, i.e. iv!="":
-	counterByte := ivGeneration(iv)
-	// we split the counter and increment only the last 64 bits so we can use the int64 type without needing to use big int: this is okay since we won't encrypt exabytes of data and since the probability for being almost at the end of the counter is too low to be worrysome. However it may be good, later, to ensure the counter doesn't reach its max value, since this is still a (low probability) bug.
-	halfCounter := counterByte[8:]
-	var count uint64
-	// we set count to be equal to the value stored as bytes in the halfCounter
-	buf := bytes.NewReader(halfCounter)
-	err := binary.Read(buf, binary.BigEndian, &count)
+	cipher, err := CTREncrypt(b, plaintext, counter)
 	if err != nil {
-		log.Fatal("binary.Read failed:", err)
+		return nil, "", err
 	}
-	var counter []string
-	for i := 0; i < len(toCrypt); i++ {
-		bif := new(bytes.Buffer)
-		// we translate the counter into bytes (from int64)
-		err = binary.Write(bif, binary.BigEndian, count)
-		if err != nil {
-			log.Fatal("binary.Write failed:", err)
-		}
-		// we append the lower 64 bits of the counter with the upper 64 bits
-		halfCounter = append(counterByte[:8], bif.Bytes()...)
-		// we add it to the list we will enrypt later
-		counter = append(counter, hex.EncodeToString(halfCounter))
-		// we increment the counter
-		count = count + uint64(1)
-		// Note that a unint64 won't overflow but wrap around in golang
+	return hexBlocks(cipher), hex.EncodeToString(counter[:]), nil
+}
+
+// This decrypts the output of AESCTR, given as one hexadecimal string, e.g. the joined blocks, with the counter it
+// returned. As CTR decryption is the same as encryption, the session's circuit is the AES one.
+func (s *Session) AESCTRDecrypt(ctx context.Context, data string, addr string, port int, iv string) ([]string, error) {
+	return aesCTRDecrypt(s.Block(ctx, addr, port), data, iv)
+}
+
+// The hexadecimal layer of the CTR decryption
+func aesCTRDecrypt(b *GarbledBlock, data string, iv string) ([]string, error) {
+	ciphertext, counter, err := hexDecryptInput(data, iv)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := CTREncrypt(b, ciphertext, counter)
+	if err != nil {
+		return nil, err
 	}
+	return hexBlocks(plain), nil
+}
 
-	// secure encryption of the counter :
-	for i, r := range counter {
-		ct := YaoClient(ReverseEndianness(r), addr, port+i)
-		cipher = append(cipher, ReverseEndianness(ct))
+// Decodes the hexadecimal data and optional IV of the string based modes. As it always did, an IV which isn't 128 bits
+// long is ignored and a random one is used instead.
+func hexModeInput(data string, o_iv ...string) ([]byte, [BlockSize]byte, error) {
+	var iv [BlockSize]byte
+	plaintext, err := hex.DecodeString(data)
+	if err != nil {
+		return nil, iv, fmt.Errorf("%w: %v", ErrInvalidHex, err)
 	}
+	//check wheter an optional iv is specified, an iv being one AES block of 128 bits whatever the key size, we conditionnally check if it has the good length to be an iv
+	custom := ""
+	if len(o_iv) > 0 && len(o_iv[0]) == 32 {
+		custom = o_iv[0]
+	}
+	ivUsed, err := ivGeneration(custom)
+	if err != nil {
+		return nil, iv, err
+	}
+	copy(iv[:], ivUsed)
+	return plaintext, iv, nil
+}
 
-	cipherText := make([]string, len(cipher))
-	for i, r := range toCrypt {
-		cipherText[i] = xorStr(cipher[i], r)
+// Decodes the hexadecimal ciphertext and IV given to the decryption of the string based modes. Unlike for the
+// encryption, the IV is required and has to be 128 bits long.
+func hexDecryptInput(data string, iv string) ([]byte, [BlockSize]byte, error) {
+	var ivBytes [BlockSize]byte
+	ciphertext, err := hex.DecodeString(data)
+	if err != nil {
+		return nil, ivBytes, fmt.Errorf("%w: %v", ErrInvalidHex, err)
 	}
+	decoded, err := hex.DecodeString(iv)
+	if err != nil || len(decoded) != BlockSize {
+		return nil, ivBytes, fmt.Errorf("%w: the iv has to be 128 bits in hexadecimal", ErrInvalidHex)
+	}
+	copy(ivBytes[:], decoded)
+	return ciphertext, ivBytes, nil
+}
 
-	return cipherText, hex.EncodeToString(counterByte)
+// Splits the output of a mode into uppercase hexadecimal blocks of 128 bits, or less for the last one, as AESCBC and
+// AESCTR always returned them
+func hexBlocks(data []byte) []string {
+	return SplitData(strings.ToUpper(hex.EncodeToString(data)), 2*BlockSize)
 }
 
 // A method allowing one to generate a random iv in a byte slice or to set this iv to the given string (assuming a big endian representation in hexadecimal) and using the secure PRNG from "crypto/rand"
-func ivGeneration(customIv string) []byte {
-	// iv generation:
-	// iv size is 128 bits:
-	ivLength := 16
-	ivByte := make([]byte, ivLength)
-	_, err := rand.Read(ivByte)
-	if err != nil {
-		log.Fatal(err)
-	}
+func ivGeneration(customIv string) ([]byte, error) {
 	// To allow the use of a given  iv (mainly for testing purpose) :
 	if customIv != "" && len(customIv) == 32 {
 		fmt.Println("\tBe careful when using a custom iv as now: randomness reuses are dangerous")
-		ivByte, err = hex.DecodeString(customIv)
+		ivByte, err := hex.DecodeString(customIv)
 		if err != nil {
-			log.Fatal(err)
+			return nil, fmt.Errorf("%w: custom iv %q", ErrInvalidHex, customIv)
 		}
+		return ivByte, nil
 	}
-
-	return ivByte
+	// iv size is 128 bits:
+	iv, err := NewIV()
+	if err != nil {
+		return nil, err
+	}
+	return iv[:], nil
 }
 
 // An utilitary function to reverse endianness from little/big to big/little endian for a string of hex values
+//
+// Deprecated: ReverseEndianness exits the program if the data has an odd length, use SwapEndianness instead.
 func ReverseEndianness(data string) string {
-	//initalizing the return value as an empty string
-	ans := ""
+	ans, err := SwapEndianness(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return ans
+}
 
+// The error returning version of ReverseEndianness, ErrInvalidHex is returned if the data has an odd length.
+func SwapEndianness(data string) (string, error) {
 	//trimming since there are easily \n in cmd lines outputs.
 	data = strings.TrimSpace(data)
 	if len(data)%2 != 0 {
-		log.Fatal("You can't change the endianness of a string whose length isn't a multiple of 2. Please check your data.")
-		return data
+		return "", fmt.Errorf("%w: you can't change the endianness of a string whose length isn't a multiple of 2", ErrInvalidHex)
 	}
 	//if the data isn't in hex format, e.g. if it hasn't an even number of char, then the programmer made some mistake. However this isn't checking it is actually hex
-
-	for len(data) >= 2 {
-		ans += data[len(data)-2:]
-		data = data[:len(data)-2]
+	ans := make([]byte, len(data))
+	for i := 0; i < len(data); i += 2 {
+		copy(ans[len(data)-i-2:], data[i:i+2])
 	}
-	return ans
+	return string(ans), nil
 }
 
-// Be careful, you have to first set the TinyGarble Path and the Circuit Path to the AES-128 circuit, in order to use this
+// Be careful, you have to first set the TinyGarble Path and the Circuit Path to the AES circuit, in order to use this
 // This function allows to run an server a given number of time "rounds", incrementing the port number each time to avoid problems with the TIME_WAIT
+//
+// Deprecated: RunServer exits the program on any failure, use Serve instead.
 func RunServer(key string, startingPort int, rounds int) {
-	// TODO : find a good way to decide weither the server can stop or not
-	// maybe establish a TCP connexion in order to communicate with
-	// Bob to decide the next port to use and/or if it is finished?
-	// However it'll be certainly easier to just timeout. As of now fixed number of rounds:
-	for rounds != 0 { // This allows unending server cycles
-		YaoServer(key, startingPort)
-		// Note that this will crash sometimes if the next port isn't available
-		startingPort++
-		rounds--
-		// This terminates when rounds == 0
+	if err := Serve(key, startingPort, rounds); err != nil {
+		log.Fatal(err)
 	}
 }
 
-// An utilitary function to set the path to the relevant component in order to be able to use TinyGarble
+// The error returning version of RunServer, using the circuit set by SetCircuit.
+func Serve(key string, startingPort int, rounds int) error {
+	return currentSession().RunServer(context.Background(), key, startingPort, rounds)
+}
+
+// An utilitary function to set the path to the relevant component in order to be able to use TinyGarble with the package level functions.
+// Prefer building a Session with NewSession, which doesn't rely on shared state.
+// The inputs are given to TinyGarble through a file, which the upstream TinyGarble doesn't support: the package level
+// functions then fail with ErrInputFileUnsupported, unless SetArgvInput(true) is called.
 func SetCircuit(tiPath string, ciPath string, clCycles int, uInput bool) {
-	tinyPath = tiPath
-	circuitPath = ciPath
-	clockCycles = clCycles
-	forceInput = uInput
+	mode := InputAuto
+	if uInput {
+		mode = InputFlag
+	}
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultSession = &Session{
+		backend:  TinyGarbleBackend{Path: tiPath, ArgvInput: defaultArgvInput},
+		circuit:  Circuit{Path: ciPath, ClockCycles: clCycles, Input: mode},
+		attempts: defaultAttempts,
+		maxDelay: defaultMaxDelay,
+	}
+}
+
+// SetArgvInput makes the package level functions give the inputs on TinyGarble's command line, as they used to, which
+// the upstream TinyGarble needs. Anyone on the host can then read them, see TinyGarbleBackend.ArgvInput. It applies to
+// the circuit already set and to the later calls to SetCircuit.
+func SetArgvInput(argv bool) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultArgvInput = argv
+	s := *defaultSession
+	if b, ok := s.backend.(TinyGarbleBackend); ok {
+		b.ArgvInput = argv
+		s.backend = b
+	}
+	defaultSession = &s
 }
 
-// An utilitary function to easily split the input data into a slice of char blocks of variable sizes as string (or less for the last block)
+// An utilitary function to easily split the input data into a slice of char blocks of variable sizes as string (or less for the last block).
+// The data is split on bytes, not runes, which is what we want for hexadecimal strings.
 func SplitData(data string, length int) []string {
 	var toCrypt []string
-	for i, _ := range data {
-		if i > 0 && (i+1)%length == 0 {
-			toCrypt = append(toCrypt, data[i-length+1:i+1])
-		} else {
-			// using len-1 because the index begin at 0, not at 1
-			if i == len(data)-1 {
-				toCrypt = append(toCrypt, data[len(data)-(len(data))%length:len(data)])
-			}
-		}
+	for len(data) > length {
+		toCrypt = append(toCrypt, data[:length])
+		data = data[length:]
+	}
+	if len(data) > 0 {
+		toCrypt = append(toCrypt, data)
 	}
 	return toCrypt
 }
 
-// Helper method to xor (hexadecimal) strings together
-func xorStr(str1 string, str2 string) string {
+// Helper method to xor (hexadecimal) strings together, the result is as long as the shortest one
+func xorStr(str1 string, str2 string) (string, error) {
 	s1, e1 := hex.DecodeString(str1)
 	s2, e2 := hex.DecodeString(str2)
 	if e1 != nil || e2 != nil {
-		log.Fatal("Decoding from string failed:", e1, e2)
+		return "", fmt.Errorf("%w: decoding from string failed: %v", ErrInvalidHex, errors.Join(e1, e2))
 	}
-	mini := len(s2)
-	if len(s1) < len(s2) {
-		mini = len(s1)
-	}
-	str := make([]byte, mini)
-	for i := 0; i < mini; i++ {
-		str[i] = s1[i] ^ s2[i]
-	}
-	return strings.ToUpper(hex.EncodeToString(str))
+	str := make([]byte, min(len(s1), len(s2)))
+	subtle.XORBytes(str, s1, s2)
+	return strings.ToUpper(hex.EncodeToString(str)), nil
 }
 
 // The wrapper function for the TinyGarble client option
+//
+// Deprecated: YaoClient exits the program on any failure, use Evaluate instead.
 func YaoClient(data string, addr string, port int) string {
-	fmt.Printf("\tClient running on address %s and port %d.\n", addr, port)
-
-	// we will use the following arguments when we run the client :
-	var yaoArgs []string
-	yaoArgs = []string{"-b", "-i", circuitPath,
-		"-s", addr, "-p", strconv.Itoa(port),
-		"--output_mode", "2"}
-	// We specify the --output_mode arg to be "last_clock", aka 2, only, since otherwise it would output each clock cycle intermediate states when using multiple cycles circuits
-
-	var inputArg []string
-	if clockCycles > 1 {
-		inputArg = []string{"--clock_cycle", strconv.Itoa(clockCycles)}
-	} else {
-		forceInput = true
-	}
-
-	if forceInput {
-		inputArg = append(inputArg, "--input", data)
-	} else {
-		inputArg = append(inputArg, "--init", data)
-	}
-
-	yaoArgs = append(yaoArgs, inputArg...)
-
-	//log.Println("Arguments used to run TinyGarble:",yaoArgs)
-	cmd := exec.Command(tinyPath+"/bin/garbled_circuit/TinyGarble", yaoArgs...)
-
-	out, err := cmd.Output()
+	out, err := Evaluate(data, addr, port)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return out
+}
 
-	return string(out)
+// The error returning wrapper for the TinyGarble client option, using the circuit set by SetCircuit.
+// It returns the raw output of TinyGarble, if TinyGarble fails, the error is a *TinyGarbleError holding its exit code.
+func Evaluate(data string, addr string, port int) (string, error) {
+	return currentSession().Client(context.Background(), data, addr, port)
 }
 
 // A wrapper function for the TinyGarble with server (alice) argument set
+//
+// Deprecated: YaoServer exits the program on any failure, use Garble instead.
 func YaoServer(data string, port int) {
-	fmt.Printf("\tServer running on port %d.\n", port)
-
-	var yaoArgs []string
-	yaoArgs = []string{"-a", "-i", circuitPath,
-		"-p", strconv.Itoa(port)}
-
-	var inputArg []string
-	if clockCycles > 1 {
-		inputArg = []string{"--clock_cycle", strconv.Itoa(clockCycles)}
-	} else {
-		forceInput = true
+	if err := Garble(data, port); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	if forceInput {
-		inputArg = append(inputArg, "--input", data)
-	} else {
-		inputArg = append(inputArg, "--init", data)
-	}
+// The error returning wrapper for the TinyGarble server (alice) option, using the circuit set by SetCircuit.
+// It returns ErrPortUnavailable if something is already listening on the port and a *TinyGarbleError if TinyGarble fails.
+func Garble(data string, port int) error {
+	return currentSession().Server(context.Background(), data, port)
+}
 
-	yaoArgs = append(yaoArgs, inputArg...)
+// Helper to convert the error returned by exec into a *TinyGarbleError
+func tinyGarbleError(err error) *TinyGarbleError {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &TinyGarbleError{ExitCode: exitErr.ExitCode(), Stderr: string(exitErr.Stderr), Err: err}
+	}
+	return &TinyGarbleError{ExitCode: -1, Err: err}
+}
 
-	_, err := exec.Command(
-		tinyPath+"/bin/garbled_circuit/TinyGarble", yaoArgs...).Output()
+// Helper checking that nothing is already listening on the given port, since TinyGarble would then simply exit with status 255
+func checkPort(port int) error {
+	l, err := net.Listen("tcp", ":"+strconv.Itoa(port))
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("%w: %d: %v", ErrPortUnavailable, port, err)
 	}
+	return l.Close()
 }
@@ -1,23 +1,31 @@
 package tinylib
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"math/rand"
 	"os"
 	"strings"
 	"testing"
-	"time"
 )
 
 func TestIvGeneration(t *testing.T) {
 	var test []byte
-	test = ivGeneration(string("12345678901234567890123456789012"))
+	test, err := ivGeneration(string("12345678901234567890123456789012"))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
 	ans := hex.EncodeToString(test)
 	if ans != "12345678901234567890123456789012" {
 		t.Error("Expected 12345678901234567890123456789012, got ", ans)
 	}
 	// Further testing of the IV generation without custom iv is not necessary: the random generator used should be tested by their creator, not here.
+
+	_, err = ivGeneration(string("1234567890123456789012345678901z"))
+	if !errors.Is(err, ErrInvalidHex) {
+		t.Error("Expected ErrInvalidHex, got ", err)
+	}
 }
 
 // Basic test to try out the conversion from Little/Big to Big/Little Endian
@@ -32,45 +40,205 @@ func TestReverseEndianness(t *testing.T) {
 	if test != "0807B7A0" {
 		t.Error("Expected 0807B7A0, got ", test)
 	}
+
+	_, err := SwapEndianness(string("DEC0ADDE0"))
+	if !errors.Is(err, ErrInvalidHex) {
+		t.Error("Expected ErrInvalidHex, got ", err)
+	}
 }
 
 // Basic tests to see if the xoring of strings works
 func TestXorStr(t *testing.T) {
 	var str1 string
-	str1 = xorStr(string("DEADC0DE"), string("DEADC0DE"))
+	str1, _ = xorStr(string("DEADC0DE"), string("DEADC0DE"))
 	if str1 != "00000000" {
 		t.Error("Expected 00000000, got ", str1)
 	}
 
-	str1 = xorStr(string("ee2eee1ff1"), string("fffffffffe"))
+	str1, _ = xorStr(string("ee2eee1ff1"), string("fffffffffe"))
 	if str1 != "11D111E00F" {
 		t.Error("Expected 11D111E00, got ", str1)
 	}
 
-	str1 = xorStr(string("00000000"), string("DEADC0DE"))
+	str1, _ = xorStr(string("00000000"), string("DEADC0DE"))
 	if str1 != "DEADC0DE" {
 		t.Error("Expected DEADC0DE, got ", str1)
 	}
+
+	_, err := xorStr(string("DEADBEEF"), string("NOTHEX"))
+	if !errors.Is(err, ErrInvalidHex) {
+		t.Error("Expected ErrInvalidHex, got ", err)
+	}
 }
 
-func TestAESServ(t *testing.T) {
-	fmt.Println("Starting AES CTR mode test")
+// The mode functions should validate their input before running anything
+func TestModesErrors(t *testing.T) {
+	_, _, err := AESCBCErr("0011", "127.0.0.1", 1234)
+	if !errors.Is(err, ErrDataTooShort) {
+		t.Error("Expected ErrDataTooShort, got ", err)
+	}
+
+	_, _, err = AESCTRErr("not hex at all", "127.0.0.1", 1234)
+	if !errors.Is(err, ErrInvalidHex) {
+		t.Error("Expected ErrInvalidHex, got ", err)
+	}
+}
+
+// Every hexadecimal decryption checks its ciphertext and IV the same way
+func TestHexDecryptInput(t *testing.T) {
+	enc, dec := localBlocks(t, "2b7e151628aed2a6abf7158809cf4f3c", PadCTS)
+	decrypts := map[string]func(data, iv string) ([]string, error){
+		"CBC": func(data, iv string) ([]string, error) { return aesCBCDecrypt(dec, data, iv) },
+		"CTR": func(data, iv string) ([]string, error) { return aesCTRDecrypt(enc, data, iv) },
+		"OFB": func(data, iv string) ([]string, error) { return aesFeedbackDecrypt(enc, modeOFB, data, iv) },
+	}
+	iv := "000102030405060708090a0b0c0d0e0f"
+	for name, decrypt := range decrypts {
+		for _, v := range [][2]string{{"zz", iv}, {"00112233445566778899aabbccddeeff", "0001"}, {"00112233445566778899aabbccddeeff", ""}} {
+			if _, err := decrypt(v[0], v[1]); !errors.Is(err, ErrInvalidHex) {
+				t.Errorf("%s: expected ErrInvalidHex for %q, got %v", name, v, err)
+			}
+		}
+		if _, err := decrypt("00112233445566778899aabbccddeeff", iv); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// A TinyGarble failure should be recognisable both as ErrTinyGarbleFailed and as a *TinyGarbleError
+func TestTinyGarbleError(t *testing.T) {
+	SetCircuit(t.TempDir(), "nowhere.scd", 1, false)
+	_, err := Evaluate("00", "127.0.0.1", 1234)
+	if !errors.Is(err, ErrTinyGarbleFailed) {
+		t.Error("Expected ErrTinyGarbleFailed, got ", err)
+	}
+	var tgErr *TinyGarbleError
+	if !errors.As(err, &tgErr) || tgErr.ExitCode != -1 {
+		t.Error("Expected a *TinyGarbleError with exit code -1, got ", err)
+	}
+}
+
+// The package level functions retry as sessions do when Alice's garbler isn't listening yet
+func TestSetCircuitRetry(t *testing.T) {
+	root := fakeTinyGarble(t, `count="$(dirname "$0")/count"
+echo x >> "$count"
+if [ "$(wc -l < "$count")" -lt 3 ]; then echo "could not connect to the server" >&2; exit 1; fi
+echo 0F`)
+	defer SetCircuit("", "", 1, false)
+	SetCircuit(root, "aes_1cc.scd", 1, false)
+	out, err := Evaluate("00", "127.0.0.1", 1234)
+	if err != nil || out != "0F\n" {
+		t.Error("Expected 0F after two retries, got", out, err)
+	}
+}
+
+// With an upstream TinyGarble, the package level functions fail clearly until SetArgvInput is called
+func TestSetArgvInput(t *testing.T) {
+	root := fakeTinyGarbleHelp(t, "  --input arg\n  --init arg", `echo "$@"`)
+	defer SetArgvInput(false)
+	defer SetCircuit("", "", 1, false)
+	SetCircuit(root, "aes_1cc.scd", 1, false)
+	if _, err := Evaluate("00", "127.0.0.1", 1234); !errors.Is(err, ErrInputFileUnsupported) {
+		t.Error("Expected ErrInputFileUnsupported, got", err)
+	}
+	SetArgvInput(true)
+	if out, err := Evaluate("0A", "127.0.0.1", 1234); err != nil || !strings.Contains(out, "--input 0A") {
+		t.Error("Expected the input on the command line, got", out, err)
+	}
+	// SetCircuit keeps it
+	SetCircuit(root, "aes_1cc.scd", 1, false)
+	if out, err := Evaluate("0B", "127.0.0.1", 1234); err != nil || !strings.Contains(out, "--input 0B") {
+		t.Error("Expected the input on the command line, got", out, err)
+	}
+}
+
+// Builds a session for the given circuit of TinyGarble's scd/netlists directory. It runs TinyGarble if $TINYGARBLE is set,
+// and the ReferenceBackend otherwise, so that the modes of operation are always tested.
+func testSession(t *testing.T, circuit string, opts ...Option) *Session {
 	path := os.Getenv("TINYGARBLE")
 	if path == "" {
-		t.Skip("skipping test; $TINYGARBLE not set")
+		opts = append([]Option{WithBackend(ReferenceBackend{}), WithCircuit(circuit)}, opts...)
+	} else {
+		// Not every circuit comes with TinyGarble, such as the AES decryption one
+		if _, err := os.Stat(path + "/scd/netlists/" + circuit); err != nil {
+			t.Skip("TinyGarble doesn't have the circuit", circuit)
+		}
+		// The test keys aren't secret, so an upstream TinyGarble can have them on its command line
+		b := TinyGarbleBackend{Path: path}
+		ok, err := b.SupportsInputFile(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.ArgvInput = !ok
+		opts = append([]Option{WithBackend(b), WithCircuit(path + "/scd/netlists/" + circuit)}, opts...)
 	}
+	s, err := NewSession(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// Runs the given server in a goroutine, the server's context is cancelled and the goroutine waited for at the end of the test,
+// so that no TinyGarble process outlives the test. The returned channel gives the error returned by the server.
+func startServer(t *testing.T, server func(context.Context) error) <-chan error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		done <- server(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-finished
+	})
+	return done
+}
+
+// The ports the tests use, shared by all of them so that they don't reuse a port in TIME_WAIT. They are below the ephemeral
+// ports Linux gives to the outgoing connections, which could take the port of a garbler not started yet.
+var testPorts, _ = NewPortManager(20000, 25000, DefaultQuarantine)
+
+// Reserves n consecutive ports for the test, they are released at its end
+func reservePorts(t *testing.T, n int) int {
+	port, err := testPorts.ReserveRange(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { testPorts.ReleaseRange(port, n) })
+	return port
+}
+
+// Starts a garbler with StartServer or StartRunServer, stopping it and waiting for it at the end of the test
+func startGarbling(t *testing.T, start func(context.Context) (*Garbler, error)) *Garbler {
+	ctx, cancel := context.WithCancel(context.Background())
+	g, err := start(ctx)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		if err := g.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+			t.Log("Garbler failed:", err)
+		}
+	})
+	return g
+}
+
+func TestAESServ(t *testing.T) {
+	fmt.Println("Starting AES CTR mode test")
 
 	key := "2b7e151628aed2a6abf7158809cf4f3c"
 
-	SetCircuit(path, path+"/scd/netlists/aes_1cc.scd", 1, false)
-	s1 := rand.NewSource(time.Now().UnixNano())
-	r1 := rand.New(s1)
-	port := r1.Intn(5000)
-	fmt.Println("Using port :", 49152+port)
-	fmt.Println("Note that this test uses randomly 4 consequent ports in the range 49152-54152. So it may fail if one of those ports is not usable. You may have to rerun it if it fails with an 'exit status 255'")
+	s := testSession(t, "aes_1cc.scd")
+	port := reservePorts(t, 4)
+	fmt.Println("Using port :", port)
 	// Using a Goroutine to run concurrently with the client. Note that it is he caller responsibility to reverse endianness if needed by the used circuit, which is the case here.
-	go RunServer(ReverseEndianness(key), 49152+port, 4)
-	time.Sleep(100 * time.Millisecond)
+	g := startGarbling(t, func(ctx context.Context) (*Garbler, error) {
+		return s.StartRunServer(ctx, ReverseEndianness(key), port, 4)
+	})
 
 	fmt.Println("Continuing test with the client")
 
@@ -78,93 +246,98 @@ func TestAESServ(t *testing.T) {
 	data := "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710"
 	awaitedResult := "874d6191b620e3261bef6864990db6ce9806f66b7970fdff8617187bb9fffdff5ae4df3edbd5d35e5b4f09020db03eab1e031dda2fbe03d1792170a0f3009cee"
 
-	ans, _ := AESCTR(data, "127.0.0.1", 49152+port, iv)
+	ans, _, err := s.AESCTR(context.Background(), data, "127.0.0.1", port, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if strings.Join(ans, "") != strings.ToUpper(awaitedResult) {
 		t.Error("Expected 874d6191b620e3261bef6864990db6ce9806f66b7970fdff8617187bb9fffdff5ae4df3edbd5d35e5b4f09020db03eab1e031dda2fbe03d1792170a0f3009cee, got ", ans)
 	} else {
 		fmt.Println("AES CTR Test passed")
 	}
+	if err := g.Wait(); err != nil {
+		t.Error("Server failed:", err)
+	}
 }
 
 func TestAESCBC(t *testing.T) {
 	fmt.Println("Testing the AES CBC mode, first starting the server :")
-	path := os.Getenv("TINYGARBLE")
-	if path == "" {
-		t.Skip("skipping test; $TINYGARBLE not set")
-	}
 
 	key := "636869636b656e207465726979616b69"
 
-	SetCircuit(path, path+"/scd/netlists/aes_1cc.scd", 1, false)
-	s1 := rand.NewSource(time.Now().UnixNano())
-	r1 := rand.New(s1)
-	port := r1.Intn(1000)
-	fmt.Println("Using port :", 49152+port)
-	fmt.Println("Note that this test assumes the localhost range 49152-50152 to be usable.",
-		"So it may fail if one of those port is not usable. You may have to rerun it if it fails with an 'exit status 255'")
+	s := testSession(t, "aes_1cc.scd")
+	port := reservePorts(t, 3)
+	fmt.Println("Using port :", port)
 	// Using a Goroutine to run concurrently with the client. Note that it is he caller responsibility to reverse endianness if needed by the used circuit, which is the case here.
-	go RunServer(ReverseEndianness(key), 49152+port, 3)
-	time.Sleep(time.Millisecond * 100)
+	g := startGarbling(t, func(ctx context.Context) (*Garbler, error) {
+		return s.StartRunServer(ctx, ReverseEndianness(key), port, 3)
+	})
 
 	iv := "00000000000000000000000000000000"
 	data := "4920776f756c64206c696b65207468652047656e6572616c20476175277320436869636b656e2c20706c656173652c"
 	awaitedResult := strings.ToUpper("97687268d6ecccc0c07b25e25ecfe584b3fffd940c16a18c1b5549d2f838029e39312523a78662d5be7fcbcc98ebf5")
 
-	ans, _ := AESCBC(data, "127.0.0.1", 49152+port, iv)
+	ans, _, err := s.AESCBC(context.Background(), data, "127.0.0.1", port, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if strings.Join(ans, "") != awaitedResult {
 		t.Error("Expected", awaitedResult, "got", ans)
 	} else {
 		t.Logf("Got the expected value")
 	}
+	if err := g.Wait(); err != nil {
+		t.Error("Server failed:", err)
+	}
 }
 
 func TestHamming1cc(t *testing.T) {
 	fmt.Println("Testing with Hamming 32bits 1 clock cycles, first starting the server :")
-	path := os.Getenv("TINYGARBLE")
-	if path == "" {
-		t.Skip("skipping test; $TINYGARBLE not set")
-	}
 
-	SetCircuit(path, path+"/scd/netlists/hamming_32bit_1cc.scd", 1, false)
+	s := testSession(t, "hamming_32bit_1cc.scd")
 
-	s1 := rand.NewSource(time.Now().UnixNano())
-	r1 := rand.New(s1)
-	port := r1.Intn(1000)
-	fmt.Println("Using port :", 49152+port)
-	fmt.Println("Note that this test assumes the localhost range 49152-50152 to be usable.")
-	go YaoServer("FF55AA77", 49152+port)
-	time.Sleep(time.Millisecond * 100)
+	port := reservePorts(t, 1)
+	fmt.Println("Using port :", port)
+	g := startGarbling(t, func(ctx context.Context) (*Garbler, error) {
+		return s.StartServer(ctx, "FF55AA77", port)
+	})
 
-	ans := YaoClient("12345678", "127.0.0.1", 49152+port)
+	ans, err := s.Client(context.Background(), "12345678", "127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if ans != "13\n" {
 		t.Error("Expected 13, got", ans)
 	} else {
 		t.Logf("Got 13 as expected")
 	}
+	if err := g.Wait(); err != nil {
+		t.Error("Server failed:", err)
+	}
 }
 
 func TestHamming8cc(t *testing.T) {
 	fmt.Println("Testing with Hamming 32bits 8 clock cycles, first starting the server :")
-	path := os.Getenv("TINYGARBLE")
-	if path == "" {
-		t.Skip("skipping test; $TINYGARBLE not set")
-	}
 
-	SetCircuit(path, path+"/scd/netlists/hamming_32bit_8cc.scd", 8, true)
+	s := testSession(t, "hamming_32bit_8cc.scd", WithClockCycles(8), WithInputMode(InputFlag))
 
-	s1 := rand.NewSource(time.Now().UnixNano())
-	r1 := rand.New(s1)
-	port := r1.Intn(1000)
-	fmt.Println("Using port :", 49152+port)
-	fmt.Println("Note that this test assumes the localhost range 49152-50152 to be usable.")
-	go YaoServer("FF55AA77", 49152+port)
-	time.Sleep(time.Millisecond * 100)
+	port := reservePorts(t, 1)
+	fmt.Println("Using port :", port)
+	g := startGarbling(t, func(ctx context.Context) (*Garbler, error) {
+		return s.StartServer(ctx, "FF55AA77", port)
+	})
 
-	ans := YaoClient("12345678", "127.0.0.1", 49152+port)
+	ans, err := s.Client(context.Background(), "12345678", "127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if ans != "13\n" {
 		t.Error("Expected 13, got", ans)
 	} else {
 		t.Logf("Got 13 as expected")
 	}
+	if err := g.Wait(); err != nil {
+		t.Error("Server failed:", err)
+	}
 }
@@ -0,0 +1,112 @@
+package tinylib
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+)
+
+// XTSEncrypt encrypts one data unit, e.g. a disk sector or a fixed size record, with XTS-AES (IEEE 1619), the sector
+// number being the tweak. Alice holds both keys of XTS: data is the AES circuit garbled with the data key, and tweak
+// the AES circuit garbled with the tweak key, which encrypts the sector number once per data unit. The data unit has
+// to be at least one block long, ErrDataTooShort is returned otherwise, and a partial last block is handled by
+// ciphertext stealing, so that the ciphertext is as long as the plaintext. data needs one garbler per block.
+func XTSEncrypt(data, tweak *GarbledBlock, sector uint64, plaintext []byte) ([]byte, error) {
+	fmt.Println("\tAES XTS started for sector", sector)
+	return xts(data, tweak, sector, plaintext, false)
+}
+
+// XTSDecrypt decrypts a data unit encrypted by XTSEncrypt. data has to be the AES decryption (inverse cipher) circuit
+// garbled with the data key, while tweak is still the AES encryption one with the tweak key.
+func XTSDecrypt(data, tweak *GarbledBlock, sector uint64, ciphertext []byte) ([]byte, error) {
+	fmt.Println("\tAES XTS decryption started for sector", sector)
+	return xts(data, tweak, sector, ciphertext, true)
+}
+
+// AESXTSEncrypt works as XTSEncrypt, Alice running RunServer with the data key from dataPort, and with the tweak key
+// from tweakPort. The session's circuit is the AES encryption one.
+func (s *Session) AESXTSEncrypt(ctx context.Context, plaintext []byte, sector uint64, addr string, dataPort, tweakPort int) ([]byte, error) {
+	return XTSEncrypt(s.Block(ctx, addr, dataPort), s.Block(ctx, addr, tweakPort), sector, plaintext)
+}
+
+// AESXTSDecrypt works as XTSDecrypt, Alice running RunServer with the data key from dataPort for the session, whose
+// circuit is the AES decryption one, and with the tweak key from tweakPort for the tweak session, whose circuit is the
+// AES encryption one as for AESXTSEncrypt.
+func (s *Session) AESXTSDecrypt(ctx context.Context, ciphertext []byte, sector uint64, addr string, dataPort int, tweak *Session, tweakPort int) ([]byte, error) {
+	return XTSDecrypt(s.Block(ctx, addr, dataPort), tweak.Block(ctx, addr, tweakPort), sector, ciphertext)
+}
+
+// Both directions of XTS, which only differ by the swap of the last two blocks of the ciphertext stealing
+func xts(data, tweak *GarbledBlock, sector uint64, src []byte, decrypt bool) ([]byte, error) {
+	if len(src) < BlockSize {
+		return nil, fmt.Errorf("%w: XTS needs at least 128 bits of data", ErrDataTooShort)
+	}
+	var t [BlockSize]byte
+	defer clear(t[:])
+	binary.LittleEndian.PutUint64(t[:], sector)
+	if err := tweak.EncryptBlock(t[:], t[:]); err != nil {
+		return nil, err
+	}
+
+	dst := make([]byte, len(src))
+	d := len(src) % BlockSize
+	full := len(src) - d
+	if d != 0 {
+		// The last full block is left for the ciphertext stealing
+		full -= BlockSize
+	}
+	for i := 0; i < full; i += BlockSize {
+		if err := xtsBlock(data, dst[i:i+BlockSize], src[i:i+BlockSize], &t); err != nil {
+			return nil, err
+		}
+		mulAlpha(&t)
+	}
+	if d == 0 {
+		return dst, nil
+	}
+
+	// The ciphertext stealing: the block before last takes the start of the partial block and the end of the last
+	// full one. When decrypting, it has to be processed with the tweak of the partial block first.
+	first, second := t, t
+	if decrypt {
+		mulAlpha(&first)
+	} else {
+		mulAlpha(&second)
+	}
+	defer clear(first[:])
+	defer clear(second[:])
+	block := make([]byte, BlockSize)
+	defer clear(block)
+	if err := xtsBlock(data, block, src[full:full+BlockSize], &first); err != nil {
+		return nil, err
+	}
+	copy(dst[full+BlockSize:], block[:d])
+	copy(block, src[full+BlockSize:])
+	if err := xtsBlock(data, dst[full:full+BlockSize], block, &second); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// One block of XTS, xored with the tweak before and after the block cipher
+func xtsBlock(b *GarbledBlock, dst, src []byte, t *[BlockSize]byte) error {
+	var x [BlockSize]byte
+	defer clear(x[:])
+	subtle.XORBytes(x[:], src, t[:])
+	if err := b.EncryptBlock(x[:], x[:]); err != nil {
+		return err
+	}
+	subtle.XORBytes(dst, x[:], t[:])
+	return nil
+}
+
+// Multiplies the tweak by the primitive element α of GF(2^128), as IEEE 1619 does: little endian, and modulo
+// x^128 + x^7 + x^2 + x + 1, in constant time
+func mulAlpha(t *[BlockSize]byte) {
+	carry := t[BlockSize-1] >> 7
+	for i := BlockSize - 1; i > 0; i-- {
+		t[i] = t[i]<<1 | t[i-1]>>7
+	}
+	t[0] = t[0]<<1 ^ byte(subtle.ConstantTimeSelect(int(carry), 0x87, 0))
+}
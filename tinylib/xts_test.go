@@ -0,0 +1,105 @@
+package tinylib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+// Vectors 1, 2 and 15 to 18 of IEEE 1619 annex B, the last ones with partial blocks. The standard writes the data unit
+// sequence numbers as their little endian bytes, so its 9a78563412 is 0x123456789a.
+var xtsVectors = []struct {
+	key1, key2 string
+	sector     uint64
+	plain      string
+	cipher     string
+}{
+	{"00000000000000000000000000000000", "00000000000000000000000000000000", 0,
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"917cf69ebd68b2ec9b9fe9a3eadda692cd43d2f59598ed858c02c2652fbf922e"},
+	{"11111111111111111111111111111111", "22222222222222222222222222222222", 0x3333333333,
+		"4444444444444444444444444444444444444444444444444444444444444444",
+		"c454185e6a16936e39334038acef838bfb186fff7480adc4289382ecd6d394f0"},
+	{"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0", "bfbebdbcbbbab9b8b7b6b5b4b3b2b1b0", 0x123456789a,
+		"000102030405060708090a0b0c0d0e0f10", "6c1625db4671522d3d7599601de7ca09ed"},
+	{"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0", "bfbebdbcbbbab9b8b7b6b5b4b3b2b1b0", 0x123456789a,
+		"000102030405060708090a0b0c0d0e0f1011", "d069444b7a7e0cab09e24447d24deb1fedbf"},
+	{"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0", "bfbebdbcbbbab9b8b7b6b5b4b3b2b1b0", 0x123456789a,
+		"000102030405060708090a0b0c0d0e0f101112", "e5df1351c0544ba1350b3363cd8ef4beedbf9d"},
+	{"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0", "bfbebdbcbbbab9b8b7b6b5b4b3b2b1b0", 0x123456789a,
+		"000102030405060708090a0b0c0d0e0f10111213", "9d84c813f719aa2c7be3f66171c7c5c2edbf9dac"},
+}
+
+func TestXTS(t *testing.T) {
+	for _, v := range xtsVectors {
+		enc, dec := localBlocks(t, v.key1, PadCTS)
+		tweak, _ := localBlocks(t, v.key2, PadCTS)
+		pt, expected := mustHex(t, v.plain), mustHex(t, v.cipher)
+		ct, err := XTSEncrypt(enc, tweak, v.sector, pt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ct, expected) {
+			t.Errorf("Sector %x, %d bytes: expected %x, got %x", v.sector, len(pt), expected, ct)
+		}
+		decrypted, err := XTSDecrypt(dec, tweak, v.sector, ct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, pt) {
+			t.Errorf("Sector %x, %d bytes: expected %x, got %x", v.sector, len(pt), pt, decrypted)
+		}
+	}
+
+	// Longer data units, with and without stealing, and the tweak changing with the sector
+	enc, dec := localBlocks(t, "27182818284590452353602874713526", PadCTS)
+	tweak, _ := localBlocks(t, "31415926535897932384626433832795", PadCTS)
+	pt := bytes.Repeat([]byte("a sector of a disk image "), 21)
+	for _, n := range []int{512, 513, 520} {
+		ct, err := XTSEncrypt(enc, tweak, 1, pt[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := XTSEncrypt(enc, tweak, 2, pt[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(ct[:BlockSize], other[:BlockSize]) {
+			t.Errorf("%d bytes: the same ciphertext for two sectors", n)
+		}
+		decrypted, err := XTSDecrypt(dec, tweak, 1, ct)
+		if err != nil || !bytes.Equal(decrypted, pt[:n]) {
+			t.Errorf("%d bytes: expected %q, got %q, %v", n, pt[:n], decrypted, err)
+		}
+	}
+
+	if _, err := XTSEncrypt(enc, tweak, 0, make([]byte, 15)); !errors.Is(err, ErrDataTooShort) {
+		t.Error("Expected ErrDataTooShort, got", err)
+	}
+}
+
+// A partial block through the garblers, Alice serving each key on its own ports
+func TestAESXTS(t *testing.T) {
+	s := testSession(t, "aes_1cc.scd")
+	v := xtsVectors[5]
+	ct, err := s.AESXTSEncrypt(context.Background(), mustHex(t, v.plain), v.sector, "127.0.0.1",
+		startAES(t, s, v.key1, 2), startAES(t, s, v.key2, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ct, mustHex(t, v.cipher)) {
+		t.Errorf("Expected %s, got %x", v.cipher, ct)
+	}
+
+	// The decryption needs the AES decryption circuit for the data key, and still the encryption one for the tweak key
+	dec := testSession(t, "aes_dec_1cc.scd")
+	pt, err := dec.AESXTSDecrypt(context.Background(), ct, v.sector, "127.0.0.1", startAES(t, dec, v.key1, 2),
+		s, startAES(t, s, v.key2, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pt, mustHex(t, v.plain)) {
+		t.Errorf("Expected %s, got %x", v.plain, pt)
+	}
+}